@@ -0,0 +1,543 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProductInput is the typed body of the admin product endpoints. Fields are pointers so that
+// PATCH can tell a missing field from a zero value.
+type ProductInput struct {
+	SKU         *string       `json:"sku"`
+	Title       *string       `json:"title"`
+	Price       *models.Money `json:"price"`
+	Size        *[]string     `json:"size"`
+	Description *string       `json:"description"`
+	Stock       *int          `json:"stock"`
+	Shipping    *bool         `json:"shipping"`
+	Categories  *[]int        `json:"categories"`
+	TaxClass    *string       `json:"tax_class"`
+	Weight      *int          `json:"weight"`
+	Length      *int          `json:"length"`
+	Width       *int          `json:"width"`
+	Height      *int          `json:"height"`
+
+	CompareAtPrice nullableMoney `json:"compare_at_price"`
+	SalePrice      nullableMoney `json:"sale_price"`
+	SaleStartsAt   nullableTime  `json:"sale_starts_at"`
+	SaleEndsAt     nullableTime  `json:"sale_ends_at"`
+}
+
+// nullableMoney is an optional amount that can also be set to null, to clear it on PATCH
+type nullableMoney struct {
+	Set   bool
+	Value *models.Money
+}
+
+func (n *nullableMoney) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.Value)
+}
+
+// nullableTime is an optional timestamp that can also be set to null, to clear it on PATCH
+type nullableTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (n *nullableTime) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.Value)
+}
+
+// apply copies the fields present in the input onto the product
+func (in ProductInput) apply(p *models.Product) {
+	if in.SKU != nil {
+		p.SKU = strings.TrimSpace(*in.SKU)
+	}
+	if in.Title != nil {
+		p.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Price != nil {
+		p.Price = *in.Price
+	}
+	if in.Size != nil {
+		p.Size = *in.Size
+	}
+	if in.Description != nil {
+		p.Description = *in.Description
+	}
+	if in.Stock != nil {
+		p.Stock = *in.Stock
+	}
+	if in.Shipping != nil {
+		p.Shipping = *in.Shipping
+	}
+	if in.TaxClass != nil {
+		p.TaxClass = strings.TrimSpace(*in.TaxClass)
+	}
+	if in.Weight != nil {
+		p.Weight = *in.Weight
+	}
+	if in.Length != nil {
+		p.Length = *in.Length
+	}
+	if in.Width != nil {
+		p.Width = *in.Width
+	}
+	if in.Height != nil {
+		p.Height = *in.Height
+	}
+	if in.CompareAtPrice.Set {
+		p.CompareAtPrice = in.CompareAtPrice.Value
+	}
+	if in.SalePrice.Set {
+		p.SalePrice = in.SalePrice.Value
+	}
+	if in.SaleStartsAt.Set {
+		p.SaleStartsAt = in.SaleStartsAt.Value
+	}
+	if in.SaleEndsAt.Set {
+		p.SaleEndsAt = in.SaleEndsAt.Value
+	}
+}
+
+// requireAll checks that every field needed to create or replace a product is present
+func (in ProductInput) requireAll() error {
+	var missing []string
+	if in.Title == nil {
+		missing = append(missing, "title")
+	}
+	if in.Price == nil {
+		missing = append(missing, "price")
+	}
+	if in.Stock == nil {
+		missing = append(missing, "stock")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing fields: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// validateProduct checks a product before it is saved. Prices must be in the store currency;
+// those sent without one are taken to be.
+func validateProduct(p *models.Product, currency string) error {
+	prices := []struct {
+		name  string
+		price *models.Money
+	}{{"price", &p.Price}, {"compare_at_price", p.CompareAtPrice}, {"sale_price", p.SalePrice}}
+
+	for _, f := range prices {
+		if f.price == nil {
+			continue
+		}
+		err := f.price.In(currency)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		if f.price.Amount < 0 {
+			return fmt.Errorf("%s must not be negative", f.name)
+		}
+	}
+
+	switch {
+	case p.Title == "":
+		return errors.New("title must not be empty")
+	case p.Stock < 0:
+		return errors.New("stock must not be negative")
+	case p.Weight < 0, p.Length < 0, p.Width < 0, p.Height < 0:
+		return errors.New("weight and dimensions must not be negative")
+	case p.CompareAtPrice != nil && p.CompareAtPrice.Amount <= p.Price.Amount:
+		return errors.New("compare_at_price must be higher than price")
+	case p.SalePrice != nil && p.SalePrice.Amount >= p.Price.Amount:
+		return errors.New("sale_price must be lower than price")
+	case p.SaleStartsAt != nil && p.SaleEndsAt != nil && !p.SaleEndsAt.After(*p.SaleStartsAt):
+		return errors.New("sale_ends_at must be after sale_starts_at")
+	}
+
+	if p.Size == nil {
+		p.Size = []string{}
+	}
+	if p.TaxClass == "" {
+		p.TaxClass = models.TaxClassStandard
+	}
+
+	return nil
+}
+
+func readProductInput(r *http.Request) (ProductInput, error) {
+	var in ProductInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid product: %w", err)
+	}
+
+	return in, nil
+}
+
+// getAdminProducts returns every product, including archived ones. With ?archived=true only
+// archived products are returned.
+func (app *application) getAdminProducts(w http.ResponseWriter, r *http.Request) {
+	products, err := app.models.DB.AllWithArchived()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("archived") == "true" {
+		archived := products[:0]
+		for _, p := range products {
+			if p.DeletedAt != nil {
+				archived = append(archived, p)
+			}
+		}
+		products = archived
+	}
+	app.withImageURLs(products...)
+
+	err = app.writeJSON(w, http.StatusOK, products, "products")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// getAdminProduct returns one product, even if it is archived
+func (app *application) getAdminProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	product, err := app.models.DB.GetWithArchived(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.withImageURLs(product)
+
+	w.Header().Set("ETag", productETag(product))
+	err = app.writeJSON(w, http.StatusOK, product, "product")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// createProduct adds a new product and answers 201 with its location
+func (app *application) createProduct(w http.ResponseWriter, r *http.Request) {
+	in, err := readProductInput(r)
+	if err == nil {
+		err = in.requireAll()
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var product models.Product
+	in.apply(&product)
+
+	err = validateProduct(&product, app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	var categories []int
+	if in.Categories != nil {
+		categories = *in.Categories
+	}
+
+	id, err := app.db(r).InsertProduct(product, categories)
+	if errors.Is(err, models.ErrUnknownCategory) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/admin/products/%d", id))
+	app.writeProduct(w, id, http.StatusCreated)
+}
+
+// updateProduct replaces a product on PUT, or changes only the fields sent on PATCH
+func (app *application) updateProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readProductInput(r)
+	if err == nil && r.Method == http.MethodPut {
+		err = in.requireAll()
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	product, err := app.models.DB.GetWithArchived(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkIfMatch(w, r, product) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		product.Description = ""
+		product.Size = nil
+		product.Shipping = false
+		product.CompareAtPrice = nil
+		product.SalePrice = nil
+		product.SaleStartsAt = nil
+		product.SaleEndsAt = nil
+		product.TaxClass = models.TaxClassStandard
+		product.Weight, product.Length, product.Width, product.Height = 0, 0, 0, 0
+	}
+	in.apply(product)
+	product.UpdatedAt = time.Now()
+
+	err = validateProduct(product, app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// PUT replaces the categories too, PATCH only when they are sent
+	var categories []int
+	if in.Categories != nil {
+		categories = *in.Categories
+	} else if r.Method == http.MethodPut {
+		categories = []int{}
+	}
+
+	err = app.db(r).UpdateProduct(*product, categories)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.preconditionFailed(w, id)
+		return
+	} else if errors.Is(err, models.ErrUnknownCategory) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeProduct(w, id, http.StatusOK)
+}
+
+// archiveProduct archives a product and answers 204
+func (app *application) archiveProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	product, err := app.models.DB.GetWithArchived(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkIfMatch(w, r, product) {
+		return
+	}
+
+	err = app.db(r).ArchiveProduct(id, product.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrEditConflict) {
+		app.preconditionFailed(w, id)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeProduct answers with the current representation of a product
+func (app *application) writeProduct(w http.ResponseWriter, id int, status int) {
+	product, err := app.models.DB.GetWithArchived(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.withImageURLs(product)
+
+	w.Header().Set("ETag", productETag(product))
+	err = app.writeJSON(w, status, product, "product")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// restoreProduct brings an archived product back into the shop
+func (app *application) restoreProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.db(r).RestoreProduct(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("archived product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ok := jsonResp{
+		OK: true,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// productHistory returns the audit log of a product, newest first
+func (app *application) productHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	history, err := app.models.DB.ProductHistory(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, history, "history")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// revertProduct puts a product back in the state it had after the given revision
+func (app *application) revertProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	revision, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("revision"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid revision parameter"))
+		return
+	}
+
+	product, err := app.models.DB.GetWithArchived(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !app.checkIfMatch(w, r, product) {
+		return
+	}
+
+	err = app.db(r).RevertProduct(id, revision, product.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("revision not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrEditConflict) {
+		app.preconditionFailed(w, id)
+		return
+	} else if errors.Is(err, models.ErrNotRevertible) || errors.Is(err, models.ErrUnknownCategory) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeProduct(w, id, http.StatusOK)
+}
+
+// checkIfMatch requires the client to send the ETag of the product it wants to change.
+// Without one it answers 428, and 412 with the current product if the product has changed since.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, product *models.Product) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		app.errorJSON(w, errors.New("If-Match header with the product ETag is required"), http.StatusPreconditionRequired)
+		return false
+	}
+
+	current := productETag(product)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+
+	app.preconditionFailed(w, product.ID)
+	return false
+}
+
+// preconditionFailed answers 412 with the current representation of the product
+func (app *application) preconditionFailed(w http.ResponseWriter, id int) {
+	app.writeProduct(w, id, http.StatusPreconditionFailed)
+}
+
+// productETag is a strong entity tag derived from the product version
+func productETag(p *models.Product) string {
+	return fmt.Sprintf(`"%d"`, p.Version)
+}
+
+func idParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		return 0, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"ecom-api/models"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// cartTokenHeader carries the token of an anonymous cart. The token is handed out with the
+// first item added to a cart without signing in, and merged into the user's cart on signin.
+const cartTokenHeader = "X-Cart-Token"
+
+// CartItemInput is the body of the cart item endpoints. Updates only read the quantity.
+type CartItemInput struct {
+	ProductID int    `json:"product_id"`
+	Size      string `json:"size"`
+	Quantity  int    `json:"quantity"`
+}
+
+func readCartItemInput(r *http.Request) (CartItemInput, error) {
+	var in CartItemInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid cart item: %w", err)
+	}
+
+	return in, nil
+}
+
+// cartOwner returns whose cart a request is for: the signed in user's, or else the anonymous
+// cart named by the cart token header. Tokens we have not issued a cart for are ignored, so that
+// clients cannot choose their own.
+func (app *application) cartOwner(r *http.Request) (models.CartOwner, error) {
+	userID, _ := r.Context().Value(userIDKey).(int)
+	if userID > 0 {
+		return models.CartOwner{UserID: userID}, nil
+	}
+
+	token := r.Header.Get(cartTokenHeader)
+	if _, err := hex.DecodeString(token); err != nil || len(token) != 64 {
+		return models.CartOwner{}, nil
+	}
+
+	issued, err := app.models.DB.CartTokenIssued(token)
+	if err != nil || !issued {
+		return models.CartOwner{}, err
+	}
+
+	return models.CartOwner{Token: token}, nil
+}
+
+// newCartToken returns a new random cart token
+func newCartToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (app *application) getCart(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.cartOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeCart(w, owner, http.StatusOK)
+}
+
+// addCartItem puts a product in the cart. An anonymous request without a cart token gets a new
+// cart, and its token in the cart token header.
+func (app *application) addCartItem(w http.ResponseWriter, r *http.Request) {
+	in, err := readCartItemInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if in.Quantity == 0 {
+		in.Quantity = 1
+	}
+	if in.Quantity < 0 {
+		app.errorJSON(w, errors.New("quantity must be positive"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	owner, err := app.cartOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if owner.UserID == 0 && owner.Token == "" {
+		owner.Token, err = newCartToken()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(cartTokenHeader, owner.Token)
+	}
+
+	err = app.models.DB.AddCartItem(owner, in.ProductID, in.Size, in.Quantity)
+	if errors.Is(err, models.ErrUnknownProduct) || errors.Is(err, models.ErrInsufficientStock) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeCart(w, owner, http.StatusOK)
+}
+
+// updateCartItem sets the quantity of a product in the cart. The size of the product is taken
+// from the size query parameter; a quantity of 0 removes it.
+func (app *application) updateCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readCartItemInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if in.Quantity < 0 {
+		app.errorJSON(w, errors.New("quantity must not be negative"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	owner, err := app.cartOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.models.DB.SetCartItemQuantity(owner, productID, r.URL.Query().Get("size"), in.Quantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not in cart"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrUnknownProduct) || errors.Is(err, models.ErrInsufficientStock) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeCart(w, owner, http.StatusOK)
+}
+
+func (app *application) removeCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	owner, err := app.cartOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.models.DB.RemoveCartItem(owner, productID, r.URL.Query().Get("size"))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not in cart"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeCart(w, owner, http.StatusOK)
+}
+
+func (app *application) clearCart(w http.ResponseWriter, r *http.Request) {
+	owner, err := app.cartOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.models.DB.ClearCart(owner)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) writeCart(w http.ResponseWriter, owner models.CartOwner, status int) {
+	cart, err := app.models.DB.GetCart(owner)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, cart, "cart")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
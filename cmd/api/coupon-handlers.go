@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CouponInput is the body of the admin coupon endpoints
+type CouponInput struct {
+	Code         string       `json:"code"`
+	Kind         string       `json:"kind"`
+	Value        models.Money `json:"value"`
+	MinOrder     models.Money `json:"min_order"`
+	ProductIDs   []int        `json:"product_ids"`
+	CategoryIDs  []int        `json:"category_ids"`
+	UsageLimit   *int         `json:"usage_limit"`
+	PerUserLimit *int         `json:"per_user_limit"`
+	StartsAt     *time.Time   `json:"starts_at"`
+	EndsAt       *time.Time   `json:"ends_at"`
+	Active       *bool        `json:"active"`
+}
+
+// coupon checks the input. Fixed values and the minimum order are in the store currency; the
+// value of percent coupons is a bare percentage.
+func (in CouponInput) coupon(currency string) (models.Coupon, error) {
+	c := models.Coupon{
+		Code:         strings.TrimSpace(in.Code),
+		Kind:         in.Kind,
+		Value:        in.Value,
+		MinOrder:     in.MinOrder,
+		ProductIDs:   in.ProductIDs,
+		CategoryIDs:  in.CategoryIDs,
+		UsageLimit:   in.UsageLimit,
+		PerUserLimit: in.PerUserLimit,
+		StartsAt:     in.StartsAt,
+		EndsAt:       in.EndsAt,
+		Active:       in.Active == nil || *in.Active,
+	}
+
+	err := c.MinOrder.In(currency)
+	if err != nil {
+		return c, fmt.Errorf("min_order: %w", err)
+	}
+	if c.Kind == models.CouponFixed {
+		err = c.Value.In(currency)
+		if err != nil {
+			return c, fmt.Errorf("value: %w", err)
+		}
+	} else if c.Value.Currency != "" {
+		return c, errors.New("value: only fixed coupons have a currency")
+	}
+
+	switch {
+	case c.Code == "":
+		return c, errors.New("code must not be empty")
+	case c.Kind != models.CouponPercent && c.Kind != models.CouponFixed && c.Kind != models.CouponFreeShipping:
+		return c, fmt.Errorf("kind must be %s, %s or %s", models.CouponPercent, models.CouponFixed, models.CouponFreeShipping)
+	case c.Kind != models.CouponFreeShipping && c.Value.Amount <= 0:
+		return c, errors.New("value must be positive")
+	case c.Kind == models.CouponPercent && c.Value.Amount > 100:
+		return c, errors.New("a percent coupon cannot be over 100")
+	case c.MinOrder.Amount < 0:
+		return c, errors.New("min_order must not be negative")
+	case c.UsageLimit != nil && *c.UsageLimit < 1, c.PerUserLimit != nil && *c.PerUserLimit < 1:
+		return c, errors.New("usage limits must be at least 1")
+	case c.StartsAt != nil && c.EndsAt != nil && !c.EndsAt.After(*c.StartsAt):
+		return c, errors.New("ends_at must be after starts_at")
+	}
+
+	return c, nil
+}
+
+func readCouponInput(r *http.Request, currency string) (models.Coupon, error) {
+	var in CouponInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return models.Coupon{}, fmt.Errorf("invalid coupon: %w", err)
+	}
+
+	return in.coupon(currency)
+}
+
+// applyCoupon previews what a coupon takes off a cart, without redeeming it
+func (app *application) applyCoupon(w http.ResponseWriter, r *http.Request) {
+	var payload CartPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.Coupon == "" {
+		app.errorJSON(w, errors.New("coupon is required"))
+		return
+	}
+
+	cart, err := payload.cart(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	result, err := app.models.DB.PreviewCoupon(&cart, payload.Coupon)
+	if errors.Is(err, models.ErrUnknownProduct) || errors.Is(err, models.ErrCouponInvalid) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, result, "coupon")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) getCoupons(w http.ResponseWriter, r *http.Request) {
+	coupons, err := app.models.DB.AllCoupons()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, coupons, "coupons")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) createCoupon(w http.ResponseWriter, r *http.Request) {
+	c, err := readCouponInput(r, app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	id, err := app.models.DB.InsertCoupon(c)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/admin/coupons/%d", id))
+	app.writeCoupon(w, id, http.StatusCreated)
+}
+
+// updateCoupon replaces a coupon. Coupons are never deleted, as orders refer to them; set active to false instead.
+func (app *application) updateCoupon(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	c, err := readCouponInput(r, app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	c.ID = id
+
+	err = app.models.DB.UpdateCoupon(c)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("coupon not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeCoupon(w, id, http.StatusOK)
+}
+
+func (app *application) writeCoupon(w http.ResponseWriter, id int, status int) {
+	c, err := app.models.DB.GetCoupon(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, c, "coupon")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
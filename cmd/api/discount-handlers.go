@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DiscountInput is the body of the admin discount endpoints
+type DiscountInput struct {
+	Name       string       `json:"name"`
+	Kind       string       `json:"kind"`
+	Value      models.Money `json:"value"`
+	ProductID  int          `json:"product_id"`
+	CategoryID int          `json:"category_id"`
+	StartsAt   *time.Time   `json:"starts_at"`
+	EndsAt     *time.Time   `json:"ends_at"`
+	Active     *bool        `json:"active"`
+}
+
+// discount checks the input. Fixed values are in the store currency; the value of percent
+// discounts is a bare percentage.
+func (in DiscountInput) discount(currency string) (models.Discount, error) {
+	d := models.Discount{
+		Name:       strings.TrimSpace(in.Name),
+		Kind:       in.Kind,
+		Value:      in.Value,
+		ProductID:  in.ProductID,
+		CategoryID: in.CategoryID,
+		StartsAt:   in.StartsAt,
+		EndsAt:     in.EndsAt,
+		Active:     in.Active == nil || *in.Active,
+	}
+
+	if d.Kind == models.DiscountFixed {
+		err := d.Value.In(currency)
+		if err != nil {
+			return d, fmt.Errorf("value: %w", err)
+		}
+	} else if d.Value.Currency != "" {
+		return d, errors.New("value: only fixed discounts have a currency")
+	}
+
+	switch {
+	case d.Name == "":
+		return d, errors.New("name must not be empty")
+	case d.Kind != models.DiscountPercent && d.Kind != models.DiscountFixed:
+		return d, fmt.Errorf("kind must be %s or %s", models.DiscountPercent, models.DiscountFixed)
+	case d.Value.Amount <= 0:
+		return d, errors.New("value must be positive")
+	case d.Kind == models.DiscountPercent && d.Value.Amount > 100:
+		return d, errors.New("a percent discount cannot be over 100")
+	case (d.ProductID == 0) == (d.CategoryID == 0):
+		return d, errors.New("exactly one of product_id and category_id is required")
+	case d.StartsAt != nil && d.EndsAt != nil && !d.EndsAt.After(*d.StartsAt):
+		return d, errors.New("ends_at must be after starts_at")
+	}
+
+	return d, nil
+}
+
+func readDiscountInput(r *http.Request) (DiscountInput, error) {
+	var in DiscountInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid discount: %w", err)
+	}
+
+	return in, nil
+}
+
+func (app *application) getDiscounts(w http.ResponseWriter, r *http.Request) {
+	discounts, err := app.models.DB.AllDiscounts()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, discounts, "discounts")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) createDiscount(w http.ResponseWriter, r *http.Request) {
+	in, err := readDiscountInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	d, err := in.discount(app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	id, err := app.models.DB.InsertDiscount(d)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/admin/discounts/%d", id))
+	app.writeDiscount(w, id, http.StatusCreated)
+}
+
+func (app *application) updateDiscount(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readDiscountInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	d, err := in.discount(app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	d.ID = id
+
+	err = app.models.DB.UpdateDiscount(d)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("discount not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeDiscount(w, id, http.StatusOK)
+}
+
+func (app *application) deleteDiscount(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.DeleteDiscount(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("discount not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) writeDiscount(w http.ResponseWriter, id int, status int) {
+	d, err := app.models.DB.GetDiscount(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, d, "discount")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
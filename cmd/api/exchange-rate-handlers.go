@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// ExchangeRateInput is the body of PUT /v1/admin/exchange-rates/:currency
+type ExchangeRateInput struct {
+	Rate float64 `json:"rate"`
+}
+
+// Currencies tells which currency orders are charged in, and which ones prices can be shown in
+type Currencies struct {
+	Store   string   `json:"store"`
+	Display []string `json:"display"`
+}
+
+// currencyParam returns the :currency route parameter, upper cased
+func currencyParam(r *http.Request) (string, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	currency := strings.ToUpper(params.ByName("currency"))
+	if !models.ValidCurrency(currency) {
+		return "", errors.New("invalid currency parameter")
+	}
+
+	return currency, nil
+}
+
+// withDisplayPrices adds prices converted to the currency asked for with ?currency= to the products.
+// Nothing is converted when no currency, or the store currency, is asked for.
+func (app *application) withDisplayPrices(r *http.Request, products ...*models.Product) error {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" || currency == app.models.DB.Currency() {
+		return nil
+	}
+
+	rate, err := app.models.DB.GetExchangeRate(currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("prices cannot be shown in %q", currency)
+	} else if err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		display := p.Pricing.Convert(rate.Currency, rate.Rate)
+		p.Display = &display
+	}
+
+	return nil
+}
+
+// getCurrencies lists the currencies a storefront can offer to show prices in
+func (app *application) getCurrencies(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.DB.AllExchangeRates()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	currencies := Currencies{Store: app.models.DB.Currency(), Display: []string{}}
+	for _, rate := range rates {
+		currencies.Display = append(currencies.Display, rate.Currency)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, currencies, "currencies")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) getExchangeRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.DB.AllExchangeRates()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, rates, "exchange_rates")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// setExchangeRate adds or replaces the rate of a display currency
+func (app *application) setExchangeRate(w http.ResponseWriter, r *http.Request) {
+	currency, err := currencyParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var in ExchangeRateInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid exchange rate: %w", err))
+		return
+	}
+
+	switch {
+	case currency == app.models.DB.Currency():
+		app.errorJSON(w, errors.New("the store currency has no exchange rate"), http.StatusUnprocessableEntity)
+		return
+	case in.Rate <= 0:
+		app.errorJSON(w, errors.New("rate must be positive"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = app.models.DB.SetExchangeRate(currency, in.Rate)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	rate, err := app.models.DB.GetExchangeRate(currency)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, rate, "exchange_rate")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) deleteExchangeRate(w http.ResponseWriter, r *http.Request) {
+	currency, err := currencyParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.DeleteExchangeRate(currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("exchange rate not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"ecom-api/models"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gmcMaxAdditionalImages is how many images besides the main one a Merchant Center item may list
+const gmcMaxAdditionalImages = 10
+
+// ExportProduct is a product as it is exported, with absolute URLs and its availability
+type ExportProduct struct {
+	ID           int                    `json:"id"`
+	SKU          string                 `json:"sku"`
+	Title        string                 `json:"title"`
+	Description  string                 `json:"description"`
+	Price        models.Money           `json:"price"`
+	Pricing      models.Pricing         `json:"pricing"`
+	Stock        int                    `json:"stock"`
+	Availability string                 `json:"availability"`
+	Shipping     bool                   `json:"shipping"`
+	Size         []string               `json:"size"`
+	Categories   []models.CategoryRef   `json:"categories"`
+	Breadcrumbs  [][]models.CategoryRef `json:"breadcrumbs"`
+	Link         string                 `json:"link,omitempty"`
+	Images       []*models.ProductImage `json:"images"`
+	Archived     bool                   `json:"archived"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// exportProducts streams the catalog as CSV, NDJSON or a Google Merchant Center RSS feed.
+// Products are read from the database a page at a time and written out as they are read.
+func (app *application) exportProducts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	includeArchived := query.Get("archived") == "true"
+
+	var exporter productExporter
+	switch query.Get("format") {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="products.csv"`)
+		exporter = newCSVExporter(w)
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		exporter = &ndjsonExporter{enc: json.NewEncoder(w)}
+	case "gmc-xml":
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		exporter = &gmcExporter{w: w, enc: xml.NewEncoder(w)}
+	default:
+		app.errorJSON(w, fmt.Errorf("format must be csv, ndjson or gmc-xml"))
+		return
+	}
+
+	// the export may take longer than the server write timeout allows for
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	base := app.baseURL(r)
+
+	err := exporter.begin()
+	if err == nil {
+		count := 0
+		err = app.models.DB.EachProduct(r.Context(), includeArchived, func(p *models.Product) error {
+			err := exporter.write(app.exportProduct(p, base))
+			if err != nil {
+				return err
+			}
+
+			count++
+			if count%100 == 0 {
+				rc.Flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = exporter.end()
+	}
+	if err != nil {
+		// the status line has been sent already, all that is left is to cut the response short
+		app.logger.Println("export:", err)
+		return
+	}
+	rc.Flush()
+}
+
+// exportProduct resolves the image URLs of a product against base
+func (app *application) exportProduct(p *models.Product, base string) ExportProduct {
+	app.withImageURLs(p)
+	for _, img := range p.Images {
+		img.URL = absoluteURL(base, img.URL)
+		for i := range img.Variants {
+			img.Variants[i].URL = absoluteURL(base, img.Variants[i].URL)
+		}
+	}
+
+	availability := "out_of_stock"
+	if p.Stock > 0 {
+		availability = "in_stock"
+	}
+
+	var link string
+	if app.config.storefrontURL != "" {
+		link = fmt.Sprintf("%s/product/%d", strings.TrimRight(app.config.storefrontURL, "/"), p.ID)
+	}
+
+	return ExportProduct{
+		ID:           p.ID,
+		SKU:          p.SKU,
+		Title:        p.Title,
+		Description:  p.Description,
+		Price:        p.Price,
+		Pricing:      p.Pricing,
+		Stock:        p.Stock,
+		Availability: availability,
+		Shipping:     p.Shipping,
+		Size:         p.Size,
+		Categories:   p.Categories,
+		Breadcrumbs:  p.Breadcrumbs,
+		Link:         link,
+		Images:       p.Images,
+		Archived:     p.DeletedAt != nil,
+		UpdatedAt:    p.UpdatedAt,
+	}
+}
+
+// baseURL is the absolute URL of the API, used to turn relative image URLs into absolute ones
+func (app *application) baseURL(r *http.Request) string {
+	if app.config.publicURL != "" {
+		return strings.TrimRight(app.config.publicURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// only a proxy of ours can be believed about the scheme the client used
+	if proto := r.Header.Get("X-Forwarded-Proto"); app.config.trustProxy && (proto == "http" || proto == "https") {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host
+}
+
+func absoluteURL(base, url string) string {
+	if url == "" || strings.Contains(url, "://") {
+		return url
+	}
+
+	return base + "/" + strings.TrimLeft(url, "/")
+}
+
+// imageURLs returns the URLs of the full size images of a product, primary image first
+func (p ExportProduct) imageURLs() []string {
+	var urls []string
+	for _, img := range p.Images {
+		if img.Primary {
+			urls = append([]string{img.URL}, urls...)
+		} else {
+			urls = append(urls, img.URL)
+		}
+	}
+
+	return urls
+}
+
+// productType is the category path of a product, as in "Men > Shoes"
+func (p ExportProduct) productType() string {
+	if len(p.Breadcrumbs) == 0 {
+		return ""
+	}
+
+	var names []string
+	for _, c := range p.Breadcrumbs[0] {
+		names = append(names, c.Name)
+	}
+
+	return strings.Join(names, " > ")
+}
+
+type productExporter interface {
+	begin() error
+	write(p ExportProduct) error
+	end() error
+}
+
+// csvExporter writes one row per product, with lists separated by "|". The import reads the
+// file back, ignoring the columns it does not set such as id, effective_price and link.
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) *csvExporter {
+	return &csvExporter{w: csv.NewWriter(w)}
+}
+
+func (e *csvExporter) begin() error {
+	return e.w.Write([]string{"id", "sku", "title", "description", "price", "effective_price", "currency", "stock", "availability",
+		"shipping", "size", "categories", "images", "link", "archived"})
+}
+
+func (e *csvExporter) write(p ExportProduct) error {
+	var categories []string
+	for _, c := range p.Categories {
+		categories = append(categories, c.Name)
+	}
+
+	err := e.w.Write([]string{
+		strconv.Itoa(p.ID),
+		p.SKU,
+		p.Title,
+		p.Description,
+		strconv.Itoa(p.Price.Amount),
+		strconv.Itoa(p.Pricing.Price.Amount),
+		p.Price.Currency,
+		strconv.Itoa(p.Stock),
+		p.Availability,
+		strconv.FormatBool(p.Shipping),
+		strings.Join(p.Size, "|"),
+		strings.Join(categories, "|"),
+		strings.Join(p.imageURLs(), "|"),
+		p.Link,
+		strconv.FormatBool(p.Archived),
+	})
+	if err != nil {
+		return err
+	}
+
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExporter writes one JSON object per line
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) begin() error {
+	return nil
+}
+
+func (e *ndjsonExporter) write(p ExportProduct) error {
+	return e.enc.Encode(p)
+}
+
+func (e *ndjsonExporter) end() error {
+	return nil
+}
+
+// gmcItem is an item of a Google Merchant Center product feed
+type gmcItem struct {
+	XMLName          xml.Name `xml:"item"`
+	ID               string   `xml:"g:id"`
+	ItemGroupID      string   `xml:"g:item_group_id,omitempty"`
+	Title            string   `xml:"g:title"`
+	Description      string   `xml:"g:description"`
+	Link             string   `xml:"g:link,omitempty"`
+	ImageLink        string   `xml:"g:image_link,omitempty"`
+	AdditionalImages []string `xml:"g:additional_image_link"`
+	Availability     string   `xml:"g:availability"`
+	Price            string   `xml:"g:price"`
+	SalePrice        string   `xml:"g:sale_price,omitempty"`
+	ProductType      string   `xml:"g:product_type,omitempty"`
+	Size             string   `xml:"g:size,omitempty"`
+	Condition        string   `xml:"g:condition"`
+	IdentifierExists string   `xml:"g:identifier_exists"`
+}
+
+// gmcExporter writes an RSS 2.0 feed in the Google Merchant Center format. Each size of a
+// product becomes its own item, grouped by the product.
+type gmcExporter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func (e *gmcExporter) begin() error {
+	_, err := io.WriteString(e.w, xml.Header+
+		`<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0"><channel><title>Products</title>`)
+	return err
+}
+
+func (e *gmcExporter) write(p ExportProduct) error {
+	id := p.SKU
+	if id == "" {
+		id = strconv.Itoa(p.ID)
+	}
+
+	item := gmcItem{
+		ID:               id,
+		Title:            p.Title,
+		Description:      p.Description,
+		Link:             p.Link,
+		Availability:     p.Availability,
+		Price:            p.Price.String(),
+		ProductType:      p.productType(),
+		Condition:        "new",
+		IdentifierExists: "no",
+	}
+
+	if p.Pricing.Price.Amount < p.Price.Amount {
+		item.SalePrice = p.Pricing.Price.String()
+	}
+
+	images := p.imageURLs()
+	if len(images) > 0 {
+		item.ImageLink = images[0]
+		images = images[1:]
+		if len(images) > gmcMaxAdditionalImages {
+			images = images[:gmcMaxAdditionalImages]
+		}
+		item.AdditionalImages = images
+	}
+
+	if len(p.Size) == 0 {
+		return e.enc.Encode(item)
+	}
+
+	item.ItemGroupID = id
+	for _, size := range p.Size {
+		variant := item
+		variant.ID = id + "-" + size
+		variant.Size = size
+
+		err := e.enc.Encode(variant)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *gmcExporter) end() error {
+	err := e.enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(e.w, `</channel></rss>`)
+	return err
+}
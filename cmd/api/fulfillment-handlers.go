@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// trackingURLs are the tracking pages of the carriers we know, filled in when a fulfillment
+// comes without a tracking URL
+var trackingURLs = map[string]string{
+	"dhl":   "https://www.dhl.com/en/express/tracking.html?AWB=%s",
+	"fedex": "https://www.fedex.com/fedextrack/?trknbr=%s",
+	"ups":   "https://www.ups.com/track?tracknum=%s",
+	"usps":  "https://tools.usps.com/go/TrackConfirmAction?tLabels=%s",
+}
+
+// FulfillmentInput is the body of the admin fulfillment endpoint. Items name order lines by
+// their index in the order, starting at 0.
+type FulfillmentInput struct {
+	Items []struct {
+		Line     int `json:"line"`
+		Quantity int `json:"quantity"`
+	} `json:"items"`
+	Carrier        string     `json:"carrier"`
+	TrackingNumber string     `json:"tracking_number"`
+	TrackingURL    string     `json:"tracking_url"`
+	ShippedAt      *time.Time `json:"shipped_at"`
+}
+
+func (in FulfillmentInput) fulfillment(orderID int) (models.Fulfillment, error) {
+	f := models.Fulfillment{
+		OrderID:        orderID,
+		Carrier:        strings.TrimSpace(in.Carrier),
+		TrackingNumber: strings.TrimSpace(in.TrackingNumber),
+		TrackingURL:    strings.TrimSpace(in.TrackingURL),
+		ShippedAt:      time.Now(),
+	}
+	if in.ShippedAt != nil {
+		f.ShippedAt = *in.ShippedAt
+	}
+
+	for _, item := range in.Items {
+		f.Items = append(f.Items, models.FulfillmentItem{Line: item.Line, Quantity: item.Quantity})
+	}
+
+	switch {
+	case len(f.Items) == 0:
+		return f, errors.New("items must not be empty")
+	case f.ShippedAt.After(time.Now().Add(time.Minute)):
+		return f, errors.New("shipped_at must not be in the future")
+	case f.TrackingNumber != "" && f.Carrier == "":
+		return f, errors.New("carrier is required with a tracking number")
+	}
+
+	if f.TrackingURL == "" && f.TrackingNumber != "" {
+		if pattern, ok := trackingURLs[strings.ToLower(f.Carrier)]; ok {
+			f.TrackingURL = fmt.Sprintf(pattern, url.QueryEscape(f.TrackingNumber))
+		}
+	}
+	if f.TrackingURL != "" {
+		u, err := url.Parse(f.TrackingURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return f, errors.New("tracking_url must be an http or https URL")
+		}
+	}
+
+	return f, nil
+}
+
+func readFulfillmentInput(r *http.Request) (FulfillmentInput, error) {
+	var in FulfillmentInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid fulfillment: %w", err)
+	}
+
+	return in, nil
+}
+
+// createFulfillment records items of an order as shipped. The order becomes shipped once all
+// of its items are, and partially shipped until then.
+func (app *application) createFulfillment(w http.ResponseWriter, r *http.Request) {
+	orderID, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readFulfillmentInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	f, err := in.fulfillment(orderID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	_, err = app.models.DB.InsertFulfillment(f)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrFulfillmentInvalid) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if errors.Is(err, models.ErrStatusTransition) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeOrder(w, orderID, http.StatusCreated)
+}
+
+// getOrderFulfillments returns an order with its fulfillments, for the admin
+func (app *application) getOrderFulfillments(w http.ResponseWriter, r *http.Request) {
+	orderID, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeOrder(w, orderID, http.StatusOK)
+}
+
+// deliverOrder marks a shipped order delivered, once all its parcels arrived. Its customer can
+// then review the products in it.
+func (app *application) deliverOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.UpdateStatus(models.CartProducts{ID: orderID, Status: models.OrderDelivered})
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrStatusTransition) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeOrder(w, orderID, http.StatusOK)
+}
+
+// getUserOrder returns one of the signed in user's orders, with the tracking of its fulfillments.
+// Orders of other users are reported as not found.
+func (app *application) getUserOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	order, err := app.models.DB.GetOrder(orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := r.Context().Value(userIDKey).(int)
+	if order.UserID != userID {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, order, "order")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) writeOrder(w http.ResponseWriter, id int, status int) {
+	order, err := app.models.DB.GetOrder(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, order, "order")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
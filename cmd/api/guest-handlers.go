@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"ecom-api/mailer"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// GuestAccountInput is the body of the endpoint turning a guest into an account. The email is
+// the one the guest ordered with.
+type GuestAccountInput struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Phone     string `json:"phone"`
+	Password  string `json:"password"`
+}
+
+// validateGuestCart checks that a cart without a user has what a guest order needs: an email
+// to confirm it to and a billing address
+func validateGuestCart(cart models.CartProducts) error {
+	addr, err := mail.ParseAddress(cart.Email)
+	if err != nil || addr.Address != cart.Email {
+		return errors.New("a valid email is required to check out as a guest")
+	}
+
+	b := cart.BillingInfo
+	if strings.TrimSpace(b.Name) == "" || strings.TrimSpace(b.Address) == "" || strings.TrimSpace(b.City) == "" {
+		return errors.New("billing_info with name, address and city is required to check out as a guest")
+	}
+
+	return nil
+}
+
+// lookupURL is the link a guest follows to see an order: the storefront's order page when
+// the storefront is known, the API otherwise
+func (app *application) lookupURL(r *http.Request, token string) string {
+	if app.config.storefrontURL != "" {
+		return fmt.Sprintf("%s/orders/lookup?token=%s", strings.TrimRight(app.config.storefrontURL, "/"), token)
+	}
+
+	return fmt.Sprintf("%s/v1/guest/orders/%s", app.baseURL(r), token)
+}
+
+// mailGuestOrder sends a guest the confirmation of an order, with its lookup link. It sends in
+// the background; an order stays placed when the mail cannot be sent.
+func (app *application) mailGuestOrder(r *http.Request, orderID int, cart models.CartProducts) {
+	msg := mailer.Message{
+		To:      cart.Email,
+		Subject: fmt.Sprintf("Your order #%d", orderID),
+		Body: fmt.Sprintf("Thank you for your order #%d of %s.\n\n"+
+			"Follow this link to see your order and track its delivery:\n%s\n\n"+
+			"Keep the link to yourself; anyone who has it can see the order.\n",
+			orderID, cart.Total, app.lookupURL(r, cart.LookupToken)),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err := app.mailer.Send(ctx, msg)
+		if err != nil {
+			app.logger.Printf("mail order %d: %v", orderID, err)
+		}
+	}()
+}
+
+// getGuestOrder returns the guest order of a lookup token, with the tracking of its fulfillments
+func (app *application) getGuestOrder(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	order, err := app.models.DB.GuestOrder(token)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, order, "order")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// convertGuest creates an account for a guest from the lookup token of one of their orders,
+// and attaches every guest order placed with the same email to it
+func (app *application) convertGuest(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	var in GuestAccountInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid account: %w", err))
+		return
+	}
+
+	if len(in.Password) < 8 {
+		app.errorJSON(w, errors.New("password must be at least 8 characters"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(in.Password), 12)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user := models.User{
+		FirstName: strings.TrimSpace(in.FirstName),
+		LastName:  strings.TrimSpace(in.LastName),
+		Phone:     strings.TrimSpace(in.Phone),
+		Password:  string(hashedPassword),
+	}
+
+	_, attached, err := app.models.DB.ConvertGuest(token, user)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrEmailTaken) {
+		app.errorJSON(w, errors.New("email already in use, sign in instead"), http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ok := jsonResp{
+		OK:      true,
+		Message: fmt.Sprintf("account created, %d orders attached", attached),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"ecom-api/imaging"
+	"ecom-api/models"
+	"ecom-api/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+type ImagePayload struct {
+	AltText   *string `json:"alt_text"`
+	SortOrder *int    `json:"sort_order"`
+	Primary   *bool   `json:"primary"`
+}
+
+// uploadProductImage validates an uploaded image, generates its resized variants and
+// adds it to the gallery of the product
+func (app *application) uploadProductImage(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid id parameter"))
+		return
+	}
+
+	_, err = app.models.DB.GetWithArchived(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	data, err := readUpload(w, r, "image", imaging.DefaultLimits.MaxBytes)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	img := models.ProductImage{
+		ProductID: id,
+		AltText:   r.FormValue("alt_text"),
+		SortOrder: -1,
+		Primary:   r.FormValue("primary") == "true",
+	}
+
+	if sortOrder := r.FormValue("sort_order"); sortOrder != "" {
+		img.SortOrder, err = strconv.Atoi(sortOrder)
+		if err != nil || img.SortOrder < 0 {
+			app.errorJSON(w, errors.New("invalid sort_order"))
+			return
+		}
+	}
+
+	img.ID, err = app.storeProductImage(r.Context(), app.db(r), img, data)
+	if errors.Is(err, imaging.ErrUnsupportedType) {
+		app.errorJSON(w, err, http.StatusUnsupportedMediaType)
+		return
+	} else if errors.Is(err, imaging.ErrInvalidImage) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.models.DB.GetProductImage(id, img.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.withImageURL(saved)
+
+	err = app.writeJSON(w, http.StatusCreated, saved, "image")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// storeProductImage validates an image, stores it with its resized variants and adds it to the
+// gallery described by img. It returns the id of the new gallery image.
+func (app *application) storeProductImage(ctx context.Context, db *models.DBModel, img models.ProductImage, data []byte) (int, error) {
+	info, err := imaging.Inspect(data, imaging.DefaultLimits)
+	if err != nil {
+		return 0, err
+	}
+
+	variants, err := imaging.Variants(data, imaging.Sizes)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", imaging.ErrInvalidImage, err)
+	}
+
+	base, err := storage.NewKey(fmt.Sprintf("products/%d", img.ProductID), "")
+	if err != nil {
+		return 0, err
+	}
+
+	img.Key = base + info.Ext
+	img.Width = info.Width
+	img.Height = info.Height
+
+	// store everything before touching the database, and clean up on failure
+	stored := []string{img.Key}
+	err = app.images.Put(ctx, img.Key, data, info.ContentType)
+
+	for _, v := range variants {
+		if err != nil {
+			break
+		}
+
+		key := fmt.Sprintf("%s-%s%s", base, v.Name, v.Ext)
+		err = app.images.Put(ctx, key, v.Data, v.ContentType)
+		stored = append(stored, key)
+
+		img.Variants = append(img.Variants, models.ImageVariant{
+			Name:   v.Name,
+			Format: v.Format,
+			Key:    key,
+			Width:  v.Width,
+			Height: v.Height,
+		})
+	}
+
+	var id int
+	if err == nil {
+		id, err = db.InsertProductImage(img)
+	}
+	if err != nil {
+		app.deleteImages(ctx, stored)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// updateProductImage changes the alt text, position or primary flag of a gallery image
+func (app *application) updateProductImage(w http.ResponseWriter, r *http.Request) {
+	productID, imageID, err := imageParams(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	img, err := app.models.DB.GetProductImage(productID, imageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("image not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var payload ImagePayload
+
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.AltText != nil {
+		img.AltText = *payload.AltText
+	}
+	if payload.SortOrder != nil {
+		img.SortOrder = *payload.SortOrder
+	}
+	if payload.Primary != nil {
+		img.Primary = *payload.Primary
+	}
+
+	err = app.db(r).UpdateProductImage(*img)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	img, err = app.models.DB.GetProductImage(productID, imageID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.withImageURL(img)
+
+	err = app.writeJSON(w, http.StatusOK, img, "image")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// deleteProductImage removes an image and all of its variants
+func (app *application) deleteProductImage(w http.ResponseWriter, r *http.Request) {
+	productID, imageID, err := imageParams(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	keys, err := app.db(r).DeleteProductImage(productID, imageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("image not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.deleteImages(r.Context(), keys)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func imageParams(r *http.Request) (int, int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	productID, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		return 0, 0, errors.New("invalid id parameter")
+	}
+
+	imageID, err := strconv.Atoi(params.ByName("image_id"))
+	if err != nil {
+		return 0, 0, errors.New("invalid image_id parameter")
+	}
+
+	return productID, imageID, nil
+}
+
+// withImageURLs resolves the stored image keys of products to public URLs
+func (app *application) withImageURLs(products ...*models.Product) {
+	for _, p := range products {
+		p.ImageURL = app.images.URL(p.Image)
+		for _, img := range p.Images {
+			app.withImageURL(img)
+		}
+	}
+}
+
+func (app *application) withImageURL(img *models.ProductImage) {
+	img.URL = app.images.URL(img.Key)
+	for i := range img.Variants {
+		img.Variants[i].URL = app.images.URL(img.Variants[i].Key)
+	}
+}
+
+// deleteImages removes images from the store. Failures only leave orphaned files behind, so they are logged.
+func (app *application) deleteImages(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		err := app.images.Delete(ctx, key)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	}
+}
+
+// readUpload reads one file of a multipart form, rejecting anything over maxBytes
+func readUpload(w http.ResponseWriter, r *http.Request, field string, maxBytes int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1024*1024)
+
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload: %w", err)
+	}
+
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		return nil, fmt.Errorf("missing %s file: %w", field, err)
+	}
+	defer file.Close()
+
+	if header.Size > maxBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", field, maxBytes)
+	}
+
+	return ioutil.ReadAll(file)
+}
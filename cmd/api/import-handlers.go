@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"ecom-api/imaging"
+	"ecom-api/models"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	maxImportBytes     = 50 * 1024 * 1024
+	defaultImportBatch = 100
+	maxImportBatch     = 1000
+)
+
+// importColumns are the CSV columns an import may use. Lists are separated by "|". Prices are
+// in minor units of the store currency; a currency column, if present, must name that currency.
+// Columns mapped to false are written by the CSV export and ignored, so that an export can be
+// edited and imported back.
+var importColumns = map[string]bool{
+	"sku": true, "title": true, "price": true, "currency": true, "description": true, "stock": true,
+	"shipping": true, "size": true, "categories": true, "images": true,
+	"id": false, "effective_price": false, "availability": false, "link": false, "archived": false,
+}
+
+// ImportLine is one NDJSON line of an import. Categories may be given by id or by name.
+type ImportLine struct {
+	SKU         string        `json:"sku"`
+	Title       string        `json:"title"`
+	Price       *models.Money `json:"price"`
+	Size        []string      `json:"size"`
+	Description string        `json:"description"`
+	Stock       *int          `json:"stock"`
+	Shipping    bool          `json:"shipping"`
+	Categories  []interface{} `json:"categories"`
+	Images      []string      `json:"images"`
+}
+
+type ImportReport struct {
+	DryRun  bool                  `json:"dry_run"`
+	Created int                   `json:"created"`
+	Updated int                   `json:"updated"`
+	Failed  int                   `json:"failed"`
+	Rows    []models.ImportResult `json:"rows"`
+}
+
+// importProducts creates or updates products in bulk from CSV or NDJSON, matching them by SKU.
+// Invalid rows are reported and skipped; valid rows are saved in transactional batches.
+func (app *application) importProducts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dryRun := query.Get("dry_run") == "true"
+
+	batchSize := defaultImportBatch
+	if b := query.Get("batch"); b != "" {
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 1 || n > maxImportBatch {
+			app.errorJSON(w, fmt.Errorf("batch must be between 1 and %d", maxImportBatch))
+			return
+		}
+		batchSize = n
+	}
+
+	categories, err := app.categoryLookup()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var rows []models.ImportRow
+	var report ImportReport
+
+	switch importFormat(r) {
+	case "csv":
+		rows, report.Rows, err = parseCSVImport(body, categories, app.models.DB.Currency())
+	case "ndjson":
+		rows, report.Rows, err = parseNDJSONImport(body, categories, app.models.DB.Currency())
+	default:
+		app.errorJSON(w, errors.New("format must be csv or ndjson"), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	report.DryRun = dryRun
+	db := app.db(r)
+	base := app.baseURL(r)
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := rows[start:end]
+
+		results, err := db.ImportProducts(batch, dryRun)
+		if err != nil {
+			app.logger.Println("import batch:", err)
+			for _, row := range batch {
+				report.Rows = append(report.Rows, models.ImportResult{
+					Line:   row.Line,
+					SKU:    row.SKU,
+					Status: "failed",
+					Errors: []string{"batch rolled back: " + err.Error()},
+				})
+			}
+			continue
+		}
+
+		if !dryRun {
+			for i := range results {
+				results[i].ImagesQueued, results[i].Errors = app.importImages(db, results[i].ProductID, batch[i].Images, base)
+			}
+		}
+
+		report.Rows = append(report.Rows, results...)
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool { return report.Rows[i].Line < report.Rows[j].Line })
+
+	for _, row := range report.Rows {
+		switch row.Status {
+		case "created":
+			report.Created++
+		case "updated":
+			report.Updated++
+		default:
+			report.Failed++
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, report, "import")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func importFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return "ndjson"
+	}
+
+	return ""
+}
+
+// categoryLookup maps category ids and lower-cased names to category ids
+func (app *application) categoryLookup() (map[string]int, error) {
+	all, err := app.models.DB.GetAllCategory()
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := make(map[string]int, 2*len(all))
+	for _, c := range all {
+		lookup[strconv.Itoa(c.ID)] = c.ID
+		lookup[strings.ToLower(c.CategoryName)] = c.ID
+	}
+
+	return lookup, nil
+}
+
+func parseCSVImport(body io.Reader, categories map[string]int, currency string) ([]models.ImportRow, []models.ImportResult, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		use, ok := importColumns[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		if use {
+			columns[name] = i
+		}
+	}
+
+	var rows []models.ImportRow
+	var failed []models.ImportResult
+	seen := make(map[string]int)
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				failed = append(failed, models.ImportResult{Line: line, Status: "failed", Errors: []string{err.Error()}})
+				continue
+			}
+			return nil, nil, err
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := models.ImportRow{
+			Line:        line,
+			SKU:         field("sku"),
+			Title:       field("title"),
+			Description: field("description"),
+			Size:        splitList(field("size")),
+			Images:      splitList(field("images")),
+		}
+
+		var errs []string
+
+		if row.Price.Amount, err = strconv.Atoi(field("price")); err != nil {
+			errs = append(errs, "price must be a whole number of minor units")
+		}
+		row.Price.Currency = strings.ToUpper(field("currency"))
+		if row.Stock, err = strconv.Atoi(field("stock")); err != nil {
+			errs = append(errs, "stock must be a whole number")
+		}
+		if s := field("shipping"); s != "" {
+			if row.Shipping, err = strconv.ParseBool(s); err != nil {
+				errs = append(errs, "shipping must be true or false")
+			}
+		}
+		if _, ok := columns["categories"]; ok {
+			var names []interface{}
+			for _, name := range splitList(field("categories")) {
+				names = append(names, name)
+			}
+			row.Categories, errs = resolveCategories(names, categories, errs)
+		}
+
+		collectImportRow(row, currency, errs, seen, &rows, &failed)
+	}
+
+	return rows, failed, nil
+}
+
+func parseNDJSONImport(body io.Reader, categories map[string]int, currency string) ([]models.ImportRow, []models.ImportResult, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []models.ImportRow
+	var failed []models.ImportResult
+	seen := make(map[string]int)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var in ImportLine
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&in)
+		if err != nil {
+			failed = append(failed, models.ImportResult{Line: line, Status: "failed", Errors: []string{err.Error()}})
+			continue
+		}
+
+		row := models.ImportRow{
+			Line:        line,
+			SKU:         strings.TrimSpace(in.SKU),
+			Title:       strings.TrimSpace(in.Title),
+			Size:        in.Size,
+			Description: in.Description,
+			Shipping:    in.Shipping,
+			Images:      in.Images,
+		}
+
+		var errs []string
+
+		if in.Price == nil {
+			errs = append(errs, "price is required")
+		} else {
+			row.Price = *in.Price
+		}
+		if in.Stock == nil {
+			errs = append(errs, "stock is required")
+		} else {
+			row.Stock = *in.Stock
+		}
+		if in.Categories != nil {
+			row.Categories, errs = resolveCategories(in.Categories, categories, errs)
+		}
+
+		collectImportRow(row, currency, errs, seen, &rows, &failed)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return rows, failed, nil
+}
+
+// collectImportRow validates what is common to every format and files the row as valid or failed
+func collectImportRow(row models.ImportRow, currency string, errs []string, seen map[string]int, rows *[]models.ImportRow, failed *[]models.ImportResult) {
+	if row.SKU == "" {
+		errs = append(errs, "sku is required")
+	} else if first, ok := seen[row.SKU]; ok {
+		errs = append(errs, fmt.Sprintf("sku already used on line %d", first))
+	} else {
+		seen[row.SKU] = row.Line
+	}
+	if row.Title == "" {
+		errs = append(errs, "title is required")
+	}
+	if err := row.Price.In(currency); err != nil {
+		errs = append(errs, "price: "+err.Error())
+	}
+	if row.Price.Amount < 0 {
+		errs = append(errs, "price must not be negative")
+	}
+	if row.Stock < 0 {
+		errs = append(errs, "stock must not be negative")
+	}
+	for _, img := range row.Images {
+		if strings.Contains(img, "://") {
+			if err := checkImageURL(img); err != nil {
+				errs = append(errs, fmt.Sprintf("image %s: %v", img, err))
+			}
+		}
+	}
+	if row.Size == nil {
+		row.Size = []string{}
+	}
+
+	if len(errs) > 0 {
+		*failed = append(*failed, models.ImportResult{Line: row.Line, SKU: row.SKU, Status: "failed", Errors: errs})
+		return
+	}
+
+	*rows = append(*rows, row)
+}
+
+// resolveCategories turns category ids or names into ids
+func resolveCategories(values []interface{}, lookup map[string]int, errs []string) ([]int, []string) {
+	ids := []int{}
+
+	for _, v := range values {
+		var key string
+		switch v := v.(type) {
+		case float64:
+			key = strconv.Itoa(int(v))
+		case string:
+			key = strings.ToLower(strings.TrimSpace(v))
+		default:
+			errs = append(errs, fmt.Sprintf("invalid category %v", v))
+			continue
+		}
+
+		id, ok := lookup[key]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown category %q", key))
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, errs
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(s, "|") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// importImages adds the images of an imported row to the product gallery. Images are either
+// http(s) URLs, which are queued for importQueuedImages to download and process like uploads, or
+// keys of images the product already has. Those, like URLs already imported and the URLs the
+// export gives the product's images (resolved against base), are skipped so that imports and
+// edited exports can be re-run. Any other key is refused, as it may belong to another product or
+// to no object at all. It returns how many images were queued.
+func (app *application) importImages(db *models.DBModel, productID int, images []string, base string) (int, []string) {
+	if len(images) == 0 {
+		return 0, nil
+	}
+
+	product, err := app.models.DB.GetWithArchived(productID)
+	if err != nil {
+		return 0, []string{"images: " + err.Error()}
+	}
+
+	existing := make(map[string]bool)
+	for _, img := range product.Images {
+		existing[img.Key] = true
+		existing[img.Source] = true
+		existing[absoluteURL(base, app.images.URL(img.Key))] = true
+	}
+
+	var downloads []string
+	var errs []string
+
+	for _, src := range images {
+		if existing[src] {
+			continue
+		}
+		existing[src] = true
+
+		if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+			errs = append(errs, fmt.Sprintf("image %s: not an http or https URL, nor an image of this product", src))
+			continue
+		}
+		downloads = append(downloads, src)
+	}
+
+	queued, err := db.QueueImageImports(productID, downloads)
+	if err != nil {
+		errs = append(errs, "images: "+err.Error())
+	}
+
+	return queued, errs
+}
+
+// errPrivateAddress is returned when an image URL leads to an address that is not public
+var errPrivateAddress = errors.New("images can only be downloaded from public addresses")
+
+// blockedNetworks are ranges that are not reachable on the internet, on top of those the net.IP
+// methods in publicIP recognise
+var blockedNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP tells whether an address is on the internet, rather than the loopback, a private or
+// link-local network, such as the cloud metadata address 169.254.169.254
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic refuses connections to addresses that are not public. It runs once the host name is
+// resolved, so that names pointing at internal addresses are caught too.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+
+	return nil
+}
+
+// checkImageURL checks that an image URL can be downloaded from: http or https, with a host
+func checkImageURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https URLs can be downloaded")
+	}
+	if u.Hostname() == "" {
+		return errors.New("URL has no host")
+	}
+
+	return nil
+}
+
+// imageClient downloads the images of imports from public addresses only, without the proxy
+// of the environment, which would hide the address it connects to
+var imageClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublic}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return checkImageURL(req.URL.String())
+	},
+}
+
+func downloadImage(ctx context.Context, src string) ([]byte, error) {
+	err := checkImageURL(src)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	if resp.ContentLength > imaging.DefaultLimits.MaxBytes {
+		return nil, fmt.Errorf("%w: larger than %d bytes", imaging.ErrInvalidImage, imaging.DefaultLimits.MaxBytes)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, imaging.DefaultLimits.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > imaging.DefaultLimits.MaxBytes {
+		return nil, fmt.Errorf("%w: larger than %d bytes", imaging.ErrInvalidImage, imaging.DefaultLimits.MaxBytes)
+	}
+
+	return data, nil
+}
@@ -1,24 +1,221 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
 	"github.com/pascaldekloe/jwt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
+type contextKey string
+
+const (
+	userIDKey      contextKey = "user_id"
+	accessLevelKey contextKey = "access_level"
+	requestIDKey   contextKey = "request_id"
+)
+
+const accessLevelAdmin = "admin"
+
+// requestID tags every request with an ID, taken from the X-Request-ID header when the client sends one
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 12)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,If-Match,X-Request-ID,X-Cart-Token,Idempotency-Key")
+		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag,Location,X-Request-ID,X-Cart-Token,Idempotent-Replayed")
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// idempotencyTTL is how long the response to a request with an idempotency key is kept for retries
+const idempotencyTTL = 24 * time.Hour
+
+// idempotent makes retries of a request that sends an Idempotency-Key header safe. Keys belong to
+// whoever sent them: the signed in user, the cart token, or on guest order routes the guest's order
+// token. Other anonymous requests cannot send a key, as it would be shared by all of them. The first
+// request with a key runs and its response is stored; a retry with the same key and the same request
+// gets the stored response back instead of running again. Reusing a key for a different request is
+// refused. Server errors are not stored, so that the request can be retried.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			app.errorJSON(w, errors.New("Idempotency-Key must be at most 255 characters"))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys are scoped to the caller, so that a key cannot replay someone else's response
+		cart, err := app.cartOwner(r)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		var owner string
+		guestToken := httprouter.ParamsFromContext(r.Context()).ByName("token")
+		switch {
+		case cart.UserID > 0:
+			owner = fmt.Sprintf("user:%d", cart.UserID)
+		case cart.Token != "":
+			owner = "cart:" + cart.Token
+		case guestToken != "":
+			// the order token is a secret, only its hash is stored
+			sum := sha256.Sum256([]byte(guestToken))
+			owner = "guest:" + hex.EncodeToString(sum[:])
+		default:
+			app.errorJSON(w, fmt.Errorf("Idempotency-Key needs a signed in user or the %s header", cartTokenHeader))
+			return
+		}
+
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.RequestURI())
+		h.Write(body)
+		hash := hex.EncodeToString(h.Sum(nil))
+
+		// a claim outlives the request only if the server went away while running it
+		now := time.Now()
+		stored, err := app.models.DB.ClaimIdempotencyKey(owner, key, hash, now.Add(-idempotencyTTL), now.Add(-requestTimeout))
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		switch {
+		case stored == nil:
+		case stored.RequestHash != hash:
+			app.errorJSON(w, errors.New("Idempotency-Key was already used for a different request"), http.StatusUnprocessableEntity)
+			return
+		case stored.Status == 0:
+			app.errorJSON(w, errors.New("a request with this Idempotency-Key is in progress"), http.StatusConflict)
+			return
+		default:
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				app.releaseIdempotencyKey(owner, key)
+				panic(p)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= 500 {
+			app.releaseIdempotencyKey(owner, key)
+			return
+		}
+
+		header := rec.Header().Clone()
+		header.Del("X-Request-ID")
+
+		err = app.models.DB.SaveIdempotentResponse(owner, key, rec.status, header, rec.body.Bytes())
+		if err != nil {
+			app.logger.Print(err)
+		}
+	})
+}
+
+func (app *application) releaseIdempotencyKey(owner, key string) {
+	err := app.models.DB.ReleaseIdempotencyKey(owner, key)
+	if err != nil {
+		app.logger.Print(err)
+	}
+}
+
+// responseRecorder keeps a copy of the response it writes
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// deprecated marks the responses of a legacy route, pointing clients to its replacement.
+// Placeholders such as {id} in the successor path are filled in from the route parameters.
+func (app *application) deprecated(successor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		link := successor
+		for _, p := range httprouter.ParamsFromContext(r.Context()) {
+			link = strings.ReplaceAll(link, "{"+p.Key+"}", url.PathEscape(p.Value))
+		}
+
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+link+">; rel=\"successor-version\"")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identify checks the token of requests that send one, and lets anonymous requests through
+func (app *application) identify(next http.Handler) http.Handler {
+	checked := app.checkToken(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		checked.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) checkToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -73,6 +270,22 @@ func (app *application) checkToken(next http.Handler) http.Handler {
 
 		log.Println("Valid User:", userID)
 
+		accessLevel, _ := claims.String("access_level")
+
+		ctx := context.WithValue(r.Context(), userIDKey, int(userID))
+		ctx = context.WithValue(ctx, accessLevelKey, accessLevel)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin lets through only users whose token was issued to an admin. It goes after checkToken.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessLevel, _ := r.Context().Value(accessLevelKey).(string)
+		if accessLevel != accessLevelAdmin {
+			app.errorJSON(w, errors.New("forbidden - admins only"), http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
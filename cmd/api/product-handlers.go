@@ -1,16 +1,14 @@
 package main
 
 import (
+	"database/sql"
 	"ecom-api/models"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -26,19 +24,89 @@ type ProductPayload struct {
 	Stock       string `json:"stock"`
 
 	CategoryID string `json:"category"`
+	Categories []int  `json:"categories"`
 }
 
+// categoryIDs returns the categories requested by the payload, or nil if none were sent at all.
+// The legacy category field may hold a single ID or a comma separated list.
+func (p ProductPayload) categoryIDs() ([]int, error) {
+	if p.Categories != nil {
+		return p.Categories, nil
+	}
+
+	if p.CategoryID == "" {
+		return nil, nil
+	}
+
+	ids := []int{}
+	for _, s := range strings.Split(p.CategoryID, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", models.ErrUnknownCategory, s)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// CartPayload is a cart sent to be priced or ordered. It is ordered for the user of the bearer
+// token, if any; a user id in the body is ignored. When ordering for a caller with a saved cart,
+// the saved cart is ordered instead of the products in the body.
 type CartPayload struct {
-	Product []Product `json:"product"`
-	UserID  int       `json:"user"`
-	Total   int       `json:"total"`
+	Product []Product    `json:"product"`
+	Total   models.Money `json:"total"`
+	Coupon  string       `json:"coupon"`
+
+	// BillingInfo is optional. When its address is sent, the order is placed with tax.
+	BillingInfo models.BillingInfo `json:"billing_info"`
+	// ShippingMethod is the id of the shipping method chosen from a quote
+	ShippingMethod int `json:"shipping_method"`
+	// Email is required for guest checkout, when no user is sent
+	Email string `json:"email"`
+}
+
+// cart turns the payload into cart lines of the signed in user of the request, if any. Product
+// IDs come as "id,size".
+func (p CartPayload) cart(r *http.Request) (models.CartProducts, error) {
+	var cart models.CartProducts
+
+	cart.ProductID = make([]string, len(p.Product))
+	cart.Size = make([]string, len(p.Product))
+	cart.Price = make([]models.Money, len(p.Product))
+	cart.Quantity = make([]string, len(p.Product))
+
+	for i := 0; i < len(p.Product); i++ {
+		productID := strings.Split(p.Product[i].ID, ",")
+		if len(productID) < 2 {
+			return cart, fmt.Errorf("invalid product %q, expected id,size", p.Product[i].ID)
+		}
+		if p.Product[i].Quantity < 1 {
+			return cart, fmt.Errorf("invalid quantity %d for product %q", p.Product[i].Quantity, productID[0])
+		}
+		cart.ProductID[i] = productID[0]
+		cart.Size[i] = productID[1]
+		cart.Price[i] = p.Product[i].Price
+		cart.Quantity[i] = strconv.Itoa(p.Product[i].Quantity)
+	}
+
+	cart.UserID = signedInUser(r)
+	cart.Total = p.Total
+	cart.CouponCode = p.Coupon
+	cart.BillingInfo = p.BillingInfo
+	cart.ShippingMethodID = p.ShippingMethod
+	if cart.UserID == 0 {
+		cart.Email = strings.TrimSpace(p.Email)
+	}
+
+	return cart, nil
 }
 
 type Product struct {
-	ID       string `json:"id"`
-	Size     string `json:"size"`
-	Price    int    `json:"price"`
-	Quantity int    `json:"quantity"`
+	ID       string       `json:"id"`
+	Size     string       `json:"size"`
+	Price    models.Money `json:"price"`
+	Quantity int          `json:"quantity"`
 }
 
 type jsonResp struct {
@@ -51,14 +119,6 @@ type OrderStatus struct {
 	Status string `json:"status"`
 }
 
-var dir string
-var imageDir string
-
-var cartUserID int
-var cartOrderID int
-
-var product models.Product
-
 func (app *application) getOneProduct(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
@@ -70,7 +130,22 @@ func (app *application) getOneProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	product, err := app.models.DB.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	app.withImageURLs(product)
 
+	err = app.withDisplayPrices(r, product)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", productETag(product))
 	err = app.writeJSON(w, http.StatusOK, product, "product")
 	if err != nil {
 		app.errorJSON(w, err)
@@ -79,7 +154,20 @@ func (app *application) getOneProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) getAllProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := app.models.DB.All()
+	db, err := app.sortedProducts(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	products, err := db.All(models.ProductFilter{})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	app.withImageURLs(products...)
+
+	err = app.withDisplayPrices(r, products...)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -107,6 +195,20 @@ func (app *application) getAllCategories(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (app *application) getCategoryTree(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.DB.CategoryTree()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, categories, "categories")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
 func (app *application) getAllProductsByCategory(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
@@ -117,7 +219,23 @@ func (app *application) getAllProductsByCategory(w http.ResponseWriter, r *http.
 		return
 	}
 
-	products, err := app.models.DB.All(categoryID)
+	db, err := app.sortedProducts(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	products, err := db.All(models.ProductFilter{
+		CategoryID:  categoryID,
+		Descendants: r.URL.Query().Get("descendants") == "true",
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	app.withImageURLs(products...)
+
+	err = app.withDisplayPrices(r, products...)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -139,17 +257,22 @@ func (app *application) deleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	productImage, err := app.models.DB.DeleteProduct(id)
+	product, err := app.models.DB.Get(id)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	log.Println("IMAGE", productImage)
+	if !app.checkIfMatch(w, r, product) {
+		return
+	}
 
-	err = os.Remove(productImage)
-	if err != nil {
-		fmt.Println(err)
+	err = app.db(r).ArchiveProduct(id, product.Version)
+	if errors.Is(err, models.ErrEditConflict) {
+		app.preconditionFailed(w, id)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err)
 		return
 	}
 
@@ -164,110 +287,148 @@ func (app *application) deleteProduct(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-//this was insertProduct
-func (app *application) uploadImage(w http.ResponseWriter, r *http.Request) {
-	r.ParseMultipartForm(10 * 1024 * 1024)
-	file, handler, err := r.FormFile("image")
+func (app *application) userCart(w http.ResponseWriter, r *http.Request) {
 
+	var payload CartPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
 		log.Println(err)
+		app.errorJSON(w, err)
 		return
 	}
-	defer file.Close()
-
-	fmt.Println("file info")
-	fmt.Println("file name:", handler.Filename)
-	fmt.Println("file size:", handler.Size)
-	fmt.Println("file type:", handler.Header.Get("Content-Type"))
 
-	tempFile, err := ioutil.TempFile("img", "img-*.jpg")
+	cart, err := payload.cart(r)
 	if err != nil {
-		log.Println(err)
+		app.errorJSON(w, err)
 		return
 	}
-	defer tempFile.Close()
 
-	fileBytes, err := ioutil.ReadAll(file)
+	owner, err := app.cartOwner(r)
 	if err != nil {
-		log.Println(err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
-	tempFile.Write(fileBytes)
-
-	fmt.Println("SUCCESS")
-
-	fmt.Println(tempFile.Name())
 
-	//files, _ := os.ReadDir(dir)
-	path, _ := filepath.Abs(dir)
-	//for _, file := range files {
-	fmt.Println("path:", path)
-	imageDir = filepath.Join(path, tempFile.Name())
-
-}
+	saved, err := app.useSavedCart(&cart, owner)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
 
-func imageDestination(i string) {
+	if cart.UserID == 0 {
+		err = validateGuestCart(cart)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+	}
 
-	product.Image = i
-	log.Println("product ID:", product.ID)
-}
+	_, orderID, err := app.models.DB.CartOrders(&cart)
+	if errors.Is(err, models.ErrUnknownProduct) || errors.Is(err, models.ErrCouponInvalid) ||
+		errors.Is(err, models.ErrShippingUnavailable) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if errors.Is(err, models.ErrInsufficientStock) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
 
-func (app *application) userCart(w http.ResponseWriter, r *http.Request) {
+	// only a saved cart was ordered; one the client kept itself leaves the saved cart alone
+	if saved {
+		app.checkedOut(owner, orderID)
+	}
 
-	var payload CartPayload
+	if cart.LookupToken != "" {
+		app.mailGuestOrder(r, orderID, cart)
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&payload)
+	// the prices charged may differ from the ones the client sent
+	cart.ID = orderID
+	err = app.writeJSON(w, http.StatusOK, cart, "order")
 	if err != nil {
-		log.Println(err)
 		app.errorJSON(w, err)
 		return
 	}
+}
 
-	var cart models.CartProducts
-
-	cart.ProductID = make([]string, len(payload.Product))
-	cart.Size = make([]string, len(payload.Product))
-	cart.Price = make([]string, len(payload.Product))
-	cart.Quantity = make([]string, len(payload.Product))
+// useSavedCart replaces the lines of cart with the items of the owner's saved cart, if the owner
+// has one with items in it, and tells whether it did. A guest's cart email is used when the body
+// has none.
+func (app *application) useSavedCart(cart *models.CartProducts, owner models.CartOwner) (bool, error) {
+	if owner.UserID == 0 && owner.Token == "" {
+		return false, nil
+	}
 
-	for i := 0; i < len(payload.Product); i++ {
-		productID := strings.Split(payload.Product[i].ID, ",")
-		cart.ProductID[i] = productID[0]
-		cart.Size[i] = productID[1]
-		cart.Price[i] = strconv.Itoa(payload.Product[i].Price)
-		cart.Quantity[i] = strconv.Itoa(payload.Product[i].Quantity)
+	saved, err := app.models.DB.GetCart(owner)
+	if err != nil {
+		return false, err
+	}
+	if len(saved.Items) == 0 {
+		return false, nil
 	}
 
-	cart.UserID = payload.UserID
-	cart.Total = payload.Total
+	cart.ProductID = make([]string, len(saved.Items))
+	cart.Size = make([]string, len(saved.Items))
+	cart.Price = make([]models.Money, len(saved.Items))
+	cart.Quantity = make([]string, len(saved.Items))
 
-	cartUserID, cartOrderID, err = app.models.DB.CartOrders(cart)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
+	for i, item := range saved.Items {
+		cart.ProductID[i] = strconv.Itoa(item.ProductID)
+		cart.Size[i] = item.Size
+		cart.Price[i] = item.Price
+		cart.Quantity[i] = strconv.Itoa(item.Quantity)
 	}
-	log.Println(cartUserID)
-	log.Println(cartOrderID)
 
+	if cart.UserID == 0 && cart.Email == "" {
+		cart.Email = saved.Email
+	}
+
+	return true, nil
+}
+
+// BillingPayload is the billing address of an order of the signed in user
+type BillingPayload struct {
+	OrderID int `json:"order_id"`
+	models.BillingInfo
 }
 
+// userBill adds the billing address of a pending order of the signed in user, and works the
+// shipping and tax on it out again. Guests send theirs with the cart.
 func (app *application) userBill(w http.ResponseWriter, r *http.Request) {
 
-	var bill models.BillingInfo
+	var payload BillingPayload
 
-	err := json.NewDecoder(r.Body).Decode(&bill)
+	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
 		log.Println(err)
 		app.errorJSON(w, err)
 		return
 	}
+	if payload.OrderID == 0 {
+		app.errorJSON(w, errors.New("order_id is required"))
+		return
+	}
 
-	bill.UserID = cartUserID
-	bill.OrderID = cartOrderID
+	bill := payload.BillingInfo
+	bill.UserID = signedInUser(r)
+	bill.OrderID = payload.OrderID
 
 	err = app.models.DB.BillingInfo(bill)
-	if err != nil {
-		app.errorJSON(w, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrOrderNotPending) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if errors.Is(err, models.ErrShippingUnavailable) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 }
@@ -286,6 +447,8 @@ func (app *application) getAllOrders(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// orderStatus moves an order to paid or cancelled, or a shipped one to delivered. Orders are
+// shipped by recording their fulfillments.
 func (app *application) orderStatus(w http.ResponseWriter, r *http.Request) {
 
 	var payload OrderStatus
@@ -297,7 +460,11 @@ func (app *application) orderStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orderID, _ := strconv.Atoi(payload.ID)
+	orderID, err := strconv.Atoi(payload.ID)
+	if err != nil || orderID < 1 {
+		app.errorJSON(w, errors.New("invalid order id"))
+		return
+	}
 
 	status := models.CartProducts{
 		ID:     orderID,
@@ -305,7 +472,13 @@ func (app *application) orderStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = app.models.DB.UpdateStatus(status)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrStatusTransition) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
@@ -324,66 +497,55 @@ func (app *application) editProducts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Println(payload.Title)
-	log.Println(payload.Size)
-
 	arraySize := strings.Split(payload.Size, ",")
 
-	var category models.Category
+	var product models.Product
 
 	if payload.ID != "0" {
 		id, _ := strconv.Atoi(payload.ID)
-		p, _ := app.models.DB.Get(id)
+		p, err := app.models.DB.GetWithArchived(id)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !app.checkIfMatch(w, r, p) {
+			return
+		}
 		product = *p
 		product.UpdatedAt = time.Now()
 	}
 
 	product.ID, _ = strconv.Atoi(payload.ID)
 	product.Title = payload.Title
-	product.Price, _ = strconv.Atoi(payload.Price)
+	price, _ := strconv.Atoi(payload.Price)
+	product.Price = models.Money{Amount: price, Currency: app.models.DB.Currency()}
 	product.Size = arraySize
 	product.Description = payload.Description
 	product.Stock, _ = strconv.Atoi(payload.Stock)
 
 	log.Println("ps:", product.Image)
-	category.ID, _ = strconv.Atoi(payload.CategoryID)
+	categoryIDs, err := payload.categoryIDs()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
 
 	log.Println("Product price:", product.Price)
 
 	if product.ID == 0 {
-		imageDestination(imageDir)
-		newProductID, err := app.models.DB.InsertProduct(product)
-		if err != nil {
-			app.errorJSON(w, err)
-			return
-		}
-
-		log.Println("category ID:", category.ID)
-
-		productCategory := models.ProductCategory{
-			ProductID:  newProductID,
-			CategoryID: category.ID,
-		}
-
-		err = app.models.DB.InsertCategory(productCategory)
-		if err != nil {
-			app.errorJSON(w, err)
-			return
-		}
-
+		_, err = app.db(r).InsertProduct(product, categoryIDs)
 	} else {
-		err = os.Remove(product.Image)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-
-		imageDestination(imageDir)
-		err = app.models.DB.UpdateProduct(product)
-		if err != nil {
-			app.errorJSON(w, err)
-			return
-		}
+		err = app.db(r).UpdateProduct(product, categoryIDs)
+	}
+	if errors.Is(err, models.ErrEditConflict) {
+		app.preconditionFailed(w, product.ID)
+		return
+	} else if errors.Is(err, models.ErrUnknownCategory) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err)
+		return
 	}
 
 	ok := jsonResp{
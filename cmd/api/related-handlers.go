@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// defaultRelatedLimit is how many related products are returned without a limit parameter
+	defaultRelatedLimit = 8
+	// maxRelatedLimit is the most related products a request can ask for
+	maxRelatedLimit = 50
+)
+
+// getRelatedProducts recommends products to show with a product: the ones an admin pinned, then
+// the ones frequently bought together with it, then others from its categories. The limit
+// parameter sets how many, 8 by default.
+func (app *application) getRelatedProducts(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	limit := defaultRelatedLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxRelatedLimit {
+			app.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", maxRelatedLimit))
+			return
+		}
+	}
+
+	related, err := app.models.DB.RelatedProducts(id, limit)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, related, "related")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) getRelatedOverrides(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeRelatedOverrides(w, id)
+}
+
+// setRelatedOverrides replaces the products pinned to the top of the related products of a
+// product, in order, and the ones hidden from them
+func (app *application) setRelatedOverrides(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var in struct {
+		Pinned []int `json:"pinned"`
+		Hidden []int `json:"hidden"`
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid related products: %w", err))
+		return
+	}
+
+	listed := map[int]bool{}
+	for _, related := range append(append([]int{}, in.Pinned...), in.Hidden...) {
+		if related == id {
+			app.errorJSON(w, errors.New("a product cannot be related to itself"), http.StatusUnprocessableEntity)
+			return
+		}
+		if listed[related] {
+			app.errorJSON(w, fmt.Errorf("product %d is listed twice", related), http.StatusUnprocessableEntity)
+			return
+		}
+		listed[related] = true
+	}
+
+	err = app.models.DB.SetRelatedOverrides(models.RelatedOverrides{ProductID: id, Pinned: in.Pinned, Hidden: in.Hidden})
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrUnknownProduct) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeRelatedOverrides(w, id)
+}
+
+func (app *application) writeRelatedOverrides(w http.ResponseWriter, id int) {
+	overrides, err := app.models.DB.GetRelatedOverrides(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, overrides, "related")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"ecom-api/models"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// setCartEmail notes where to remind the owner of an anonymous cart about it, should it be
+// abandoned. Like adding an item, it starts a cart for a request without a cart token.
+func (app *application) setCartEmail(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid cart email: %w", err))
+		return
+	}
+
+	in.Email = strings.TrimSpace(in.Email)
+	addr, err := mail.ParseAddress(in.Email)
+	if err != nil || addr.Address != in.Email {
+		app.errorJSON(w, errors.New("email must be a valid email address"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	owner, err := app.cartOwner(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if owner.UserID == 0 && owner.Token == "" {
+		owner.Token, err = newCartToken()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(cartTokenHeader, owner.Token)
+	}
+
+	err = app.models.DB.SetCartEmail(owner, in.Email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeCart(w, owner, http.StatusOK)
+}
+
+// unsubscribePage is shown by the unsubscribe link in the reminders. Following the link only
+// asks for a confirmation, so that mail scanners opening links do not unsubscribe anyone.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{if .Done}}
+<p>{{.Email}} will no longer get reminders about carts left behind.</p>
+{{else}}
+<p>Stop reminders about carts left behind to {{.Email}}?</p>
+<form method="post" action="{{.Action}}"><button type="submit">Unsubscribe</button></form>
+{{end}}
+</body>
+</html>
+`))
+
+// unsubscribeEmail returns the email of a signed unsubscribe link, or an error when the link
+// is not one we made
+func (app *application) unsubscribeEmail(r *http.Request) (string, error) {
+	email := r.URL.Query().Get("email")
+	sig, err := hex.DecodeString(r.URL.Query().Get("sig"))
+	if err != nil || email == "" || !hmac.Equal(sig, app.unsubscribeSignature(email)) {
+		return "", errors.New("invalid unsubscribe link")
+	}
+
+	return email, nil
+}
+
+// confirmUnsubscribe asks to confirm stopping cart reminders to the email of an unsubscribe link
+func (app *application) confirmUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	email, err := app.unsubscribeEmail(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeUnsubscribePage(w, email, r.URL.RequestURI(), false)
+}
+
+// unsubscribe stops cart reminders to an email. It is posted from the confirmation page, or by
+// mail clients unsubscribing in one click, to the link in the reminders, which is signed so that
+// nobody can unsubscribe someone else.
+func (app *application) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	email, err := app.unsubscribeEmail(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.Unsubscribe(email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the confirmation page posts a form, and gets a page back
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		app.writeUnsubscribePage(w, email, "", true)
+		return
+	}
+
+	ok := jsonResp{
+		OK:      true,
+		Message: "unsubscribed",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) writeUnsubscribePage(w http.ResponseWriter, email, action string, done bool) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := unsubscribePage.Execute(w, struct {
+		Email  string
+		Action string
+		Done   bool
+	}{email, action, done})
+	if err != nil {
+		app.logger.Print(err)
+	}
+}
+
+func (app *application) unsubscribeSignature(email string) []byte {
+	mac := hmac.New(sha256.New, []byte(app.config.jwt.secret))
+	mac.Write([]byte("unsubscribe:" + strings.ToLower(email)))
+	return mac.Sum(nil)
+}
+
+func (app *application) unsubscribeURL(email string) string {
+	v := url.Values{}
+	v.Set("email", email)
+	v.Set("sig", hex.EncodeToString(app.unsubscribeSignature(email)))
+
+	return strings.TrimRight(app.config.publicURL, "/") + "/v1/unsubscribe?" + v.Encode()
+}
+
+// restoreURL is the link that brings a customer back to an abandoned cart. Anonymous carts are
+// restored with their cart token; signed in users find theirs once they sign in.
+func (app *application) restoreURL(cart *models.Cart) string {
+	base := app.config.storefrontURL
+	if base == "" {
+		base = app.config.publicURL
+	}
+	link := strings.TrimRight(base, "/") + "/cart"
+
+	if cart.UserID == 0 && cart.Token != "" {
+		link += "?cart_token=" + url.QueryEscape(cart.Token)
+	}
+
+	return link
+}
+
+// checkedOut empties the cart an order was placed from, and credits reminders about it
+func (app *application) checkedOut(owner models.CartOwner, orderID int) {
+	err := app.models.DB.CartCheckedOut(owner, orderID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.logger.Printf("check out cart of order %d: %v", orderID, err)
+	}
+}
+
+// getCartRecoveryStats reports how many abandoned carts reminders brought back, over the
+// last 30 days or since the time in the since parameter
+func (app *application) getCartRecoveryStats(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().AddDate(0, 0, -30)
+	if s := r.URL.Query().Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			app.errorJSON(w, errors.New("since must be an RFC 3339 time"))
+			return
+		}
+		since = t
+	}
+
+	stats, err := app.models.DB.CartRecoveryStats(since)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, stats, "stats")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// ReviewInput is the body of the endpoint posting a review
+type ReviewInput struct {
+	Rating int    `json:"rating"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+}
+
+func (in ReviewInput) review(productID, userID int) (models.Review, error) {
+	r := models.Review{
+		ProductID: productID,
+		UserID:    userID,
+		Rating:    in.Rating,
+		Title:     strings.TrimSpace(in.Title),
+		Body:      strings.TrimSpace(in.Body),
+	}
+
+	switch {
+	case r.Rating < 1 || r.Rating > 5:
+		return r, errors.New("rating must be between 1 and 5")
+	case r.Title == "":
+		return r, errors.New("title must not be empty")
+	case utf8.RuneCountInString(r.Title) > 200:
+		return r, errors.New("title must be at most 200 characters")
+	case utf8.RuneCountInString(r.Body) > 5000:
+		return r, errors.New("body must be at most 5000 characters")
+	}
+
+	return r, nil
+}
+
+// sortedProducts returns the models listing products in the order of the sort parameter,
+// title or rating
+func (app *application) sortedProducts(r *http.Request) (*models.DBModel, error) {
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "title"
+	}
+	if !models.ValidProductSort(sort) {
+		return nil, errors.New("sort must be title or rating")
+	}
+
+	return app.models.DB.SortedBy(sort), nil
+}
+
+// getProductReviews returns the approved reviews of a product, newest first, or in the order
+// of the sort parameter: helpful or rating
+func (app *application) getProductReviews(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "newest"
+	}
+	if !models.ValidReviewSort(sort) {
+		app.errorJSON(w, errors.New("sort must be newest, helpful or rating"))
+		return
+	}
+
+	reviews, err := app.models.DB.ProductReviews(id, sort)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, reviews, "reviews")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// createReview posts a review of a product by the signed in user. It is shown once a moderator
+// approves it.
+func (app *application) createReview(w http.ResponseWriter, r *http.Request) {
+	productID, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var in ReviewInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid review: %w", err))
+		return
+	}
+
+	review, err := in.review(productID, signedInUser(r))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	id, err := app.models.DB.InsertReview(review)
+	if errors.Is(err, models.ErrNotPurchased) {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	} else if errors.Is(err, models.ErrReviewExists) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeReview(w, id, http.StatusCreated)
+}
+
+// voteReviewHelpful counts the signed in user finding a review helpful. Voting twice counts once.
+func (app *application) voteReviewHelpful(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	count, err := app.models.DB.VoteReviewHelpful(id, signedInUser(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("review not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]int{"review_id": id, "helpful_count": count}, "vote")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// getReviews returns the reviews in the moderation state of the status parameter, pending by
+// default, oldest first
+func (app *application) getReviews(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReviewPending
+	}
+	if !validReviewStatus(status) {
+		app.errorJSON(w, reviewStatusError)
+		return
+	}
+
+	reviews, err := app.models.DB.ReviewsByStatus(status)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, reviews, "reviews")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// moderateReview approves or rejects a review, or puts it back to pending
+func (app *application) moderateReview(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var in struct {
+		Status string `json:"status"`
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid moderation: %w", err))
+		return
+	}
+	if !validReviewStatus(in.Status) {
+		app.errorJSON(w, reviewStatusError, http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = app.models.DB.ModerateReview(id, in.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("review not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeReview(w, id, http.StatusOK)
+}
+
+var reviewStatusError = fmt.Errorf("status must be %s, %s or %s", models.ReviewPending, models.ReviewApproved, models.ReviewRejected)
+
+func validReviewStatus(status string) bool {
+	return status == models.ReviewPending || status == models.ReviewApproved || status == models.ReviewRejected
+}
+
+func (app *application) writeReview(w http.ResponseWriter, id int, status int) {
+	review, err := app.models.DB.GetReview(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, review, "review")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -9,14 +9,30 @@ import (
 
 func (app *application) wrap(next http.Handler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		ctx := context.WithValue(r.Context(), "params", ps)
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, ps)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
+// actions routes requests whose :id segment names a collection action, such as
+// /v1/admin/products/import, to that action's handler. httprouter cannot register a
+// static segment next to a wildcard, so those routes share the wildcard route.
+func (app *application) actions(next httprouter.Handle, actions map[string]httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if action, ok := actions[ps.ByName("id")]; ok {
+			action(w, r, ps)
+			return
+		}
+		next(w, r, ps)
+	}
+}
+
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 	secure := alice.New(app.checkToken)
+	admin := secure.Append(app.requireAdmin)
+	identify := alice.New(app.identify)
+	idempotent := alice.New(app.idempotent)
 
 	router.HandlerFunc(http.MethodGet, "/status", app.statusHandler)
 
@@ -24,24 +40,118 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPost, "/v1/signup", app.signup)
 
 	router.HandlerFunc(http.MethodGet, "/v1/product/:id", app.getOneProduct)
+	router.HandlerFunc(http.MethodPost, "/v1/product/:id/notify", app.subscribeStock)
+	router.HandlerFunc(http.MethodGet, "/v1/product/:id/related", app.getRelatedProducts)
+	router.HandlerFunc(http.MethodGet, "/v1/product/:id/reviews", app.getProductReviews)
+	router.POST("/v1/product/:id/reviews", app.wrap(secure.ThenFunc(app.createReview)))
+	router.POST("/v1/reviews/:id/helpful", app.wrap(secure.ThenFunc(app.voteReviewHelpful)))
 	router.HandlerFunc(http.MethodGet, "/v1/products", app.getAllProducts)
 	router.HandlerFunc(http.MethodGet, "/v1/products/:category_id", app.getAllProductsByCategory)
 
+	router.HandlerFunc(http.MethodGet, "/v1/currencies", app.getCurrencies)
+
 	router.HandlerFunc(http.MethodGet, "/v1/categories", app.getAllCategories)
+	router.HandlerFunc(http.MethodGet, "/v1/categories/tree", app.getCategoryTree)
+
+	// deprecated, replaced by the /v1/admin/products routes
+	router.POST("/v1/admin/editproduct", app.wrap(app.deprecated("/v1/admin/products", admin.ThenFunc(app.editProducts))))
+	router.GET("/v1/admin/deleteproduct/:id", app.wrap(app.deprecated("/v1/admin/products/{id}", admin.ThenFunc(app.deleteProduct))))
+
+	router.GET("/v1/admin/products", app.wrap(admin.ThenFunc(app.getAdminProducts)))
+	router.POST("/v1/admin/products", app.wrap(admin.ThenFunc(app.createProduct)))
+	router.GET("/v1/admin/products/:id", app.actions(app.wrap(admin.ThenFunc(app.getAdminProduct)), map[string]httprouter.Handle{
+		"export": app.wrap(admin.ThenFunc(app.exportProducts)),
+	}))
+	router.POST("/v1/admin/products/:id", app.actions(app.wrap(admin.ThenFunc(app.notFound)), map[string]httprouter.Handle{
+		"import": app.wrap(admin.ThenFunc(app.importProducts)),
+	}))
+	router.PUT("/v1/admin/products/:id", app.wrap(admin.ThenFunc(app.updateProduct)))
+	router.PATCH("/v1/admin/products/:id", app.wrap(admin.ThenFunc(app.updateProduct)))
+	router.DELETE("/v1/admin/products/:id", app.wrap(admin.ThenFunc(app.archiveProduct)))
+	router.POST("/v1/admin/products/:id/restore", app.wrap(admin.ThenFunc(app.restoreProduct)))
+	router.POST("/v1/admin/products/:id/inventory", app.wrap(admin.ThenFunc(app.adjustStock)))
+	router.GET("/v1/admin/products/:id/related", app.wrap(admin.ThenFunc(app.getRelatedOverrides)))
+	router.PUT("/v1/admin/products/:id/related", app.wrap(admin.ThenFunc(app.setRelatedOverrides)))
+	router.GET("/v1/admin/products/:id/history", app.wrap(admin.ThenFunc(app.productHistory)))
+	router.POST("/v1/admin/products/:id/history/:revision/revert", app.wrap(admin.ThenFunc(app.revertProduct)))
+	router.POST("/v1/admin/products/:id/images", app.wrap(admin.ThenFunc(app.uploadProductImage)))
+	router.PATCH("/v1/admin/products/:id/images/:image_id", app.wrap(admin.ThenFunc(app.updateProductImage)))
+	router.DELETE("/v1/admin/products/:id/images/:image_id", app.wrap(admin.ThenFunc(app.deleteProductImage)))
+
+	router.GET("/v1/admin/discounts", app.wrap(admin.ThenFunc(app.getDiscounts)))
+	router.POST("/v1/admin/discounts", app.wrap(admin.ThenFunc(app.createDiscount)))
+	router.PUT("/v1/admin/discounts/:id", app.wrap(admin.ThenFunc(app.updateDiscount)))
+	router.DELETE("/v1/admin/discounts/:id", app.wrap(admin.ThenFunc(app.deleteDiscount)))
+
+	router.GET("/v1/admin/coupons", app.wrap(admin.ThenFunc(app.getCoupons)))
+	router.POST("/v1/admin/coupons", app.wrap(admin.ThenFunc(app.createCoupon)))
+	router.PUT("/v1/admin/coupons/:id", app.wrap(admin.ThenFunc(app.updateCoupon)))
 
-	router.POST("/v1/admin/editproduct", app.wrap(secure.ThenFunc(app.editProducts)))
-	router.GET("/v1/admin/deleteproduct/:id", app.wrap(secure.ThenFunc(app.deleteProduct)))
+	router.GET("/v1/admin/tax-rates", app.wrap(admin.ThenFunc(app.getTaxRates)))
+	router.POST("/v1/admin/tax-rates", app.wrap(admin.ThenFunc(app.createTaxRate)))
+	router.PUT("/v1/admin/tax-rates/:id", app.wrap(admin.ThenFunc(app.updateTaxRate)))
+	router.DELETE("/v1/admin/tax-rates/:id", app.wrap(admin.ThenFunc(app.deleteTaxRate)))
+
+	router.GET("/v1/admin/shipping-zones", app.wrap(admin.ThenFunc(app.getShippingZones)))
+	router.POST("/v1/admin/shipping-zones", app.wrap(admin.ThenFunc(app.createShippingZone)))
+	router.PUT("/v1/admin/shipping-zones/:id", app.wrap(admin.ThenFunc(app.updateShippingZone)))
+	router.DELETE("/v1/admin/shipping-zones/:id", app.wrap(admin.ThenFunc(app.deleteShippingZone)))
+	router.POST("/v1/admin/shipping-methods", app.wrap(admin.ThenFunc(app.createShippingMethod)))
+	router.PUT("/v1/admin/shipping-methods/:id", app.wrap(admin.ThenFunc(app.updateShippingMethod)))
+	router.DELETE("/v1/admin/shipping-methods/:id", app.wrap(admin.ThenFunc(app.deleteShippingMethod)))
+
+	router.GET("/v1/admin/orders/:id/fulfillments", app.wrap(admin.ThenFunc(app.getOrderFulfillments)))
+	router.POST("/v1/admin/orders/:id/fulfillments", app.wrap(admin.ThenFunc(app.createFulfillment)))
+	router.POST("/v1/admin/orders/:id/delivered", app.wrap(admin.ThenFunc(app.deliverOrder)))
+
+	router.GET("/v1/admin/reviews", app.wrap(admin.ThenFunc(app.getReviews)))
+	router.PUT("/v1/admin/reviews/:id", app.wrap(admin.ThenFunc(app.moderateReview)))
+
+	router.GET("/v1/admin/abandoned-carts/stats", app.wrap(admin.ThenFunc(app.getCartRecoveryStats)))
+
+	router.GET("/v1/admin/exchange-rates", app.wrap(admin.ThenFunc(app.getExchangeRates)))
+	router.PUT("/v1/admin/exchange-rates/:currency", app.wrap(admin.ThenFunc(app.setExchangeRate)))
+	router.DELETE("/v1/admin/exchange-rates/:currency", app.wrap(admin.ThenFunc(app.deleteExchangeRate)))
 
 	//router.HandlerFunc(http.MethodPost, "/v1/admin/editproduct", app.editProducts)
 	//router.HandlerFunc(http.MethodGet, "/v1/admin/deleteproduct/:id", app.deleteProduct)
 
-	router.HandlerFunc(http.MethodPost, "/v1/cart", app.userCart)
-	router.HandlerFunc(http.MethodPost, "/v1/billing", app.userBill)
+	router.Handler(http.MethodPost, "/v1/cart", identify.Append(app.idempotent).ThenFunc(app.userCart))
+	router.GET("/v1/cart", app.wrap(identify.ThenFunc(app.getCart)))
+	router.DELETE("/v1/cart", app.wrap(identify.ThenFunc(app.clearCart)))
+	router.PUT("/v1/cart/email", app.wrap(identify.ThenFunc(app.setCartEmail)))
+	router.POST("/v1/cart/items", app.wrap(identify.Append(app.idempotent).ThenFunc(app.addCartItem)))
+	router.PUT("/v1/cart/items/:id", app.wrap(identify.ThenFunc(app.updateCartItem)))
+	router.DELETE("/v1/cart/items/:id", app.wrap(identify.ThenFunc(app.removeCartItem)))
+	router.Handler(http.MethodPost, "/v1/cart/apply-coupon", identify.ThenFunc(app.applyCoupon))
+	router.Handler(http.MethodPost, "/v1/shipping/quote", identify.ThenFunc(app.quoteShipping))
+	router.Handler(http.MethodPost, "/v1/billing", secure.Append(app.idempotent).ThenFunc(app.userBill))
+
+	router.HandlerFunc(http.MethodGet, "/v1/unsubscribe", app.confirmUnsubscribe)
+	router.HandlerFunc(http.MethodPost, "/v1/unsubscribe", app.unsubscribe)
+
+	router.HandlerFunc(http.MethodGet, "/v1/guest/orders/:token", app.getGuestOrder)
+	router.Handler(http.MethodPost, "/v1/guest/orders/:token/account", idempotent.ThenFunc(app.convertGuest))
+
+	router.GET("/v1/me/wishlist", app.wrap(secure.ThenFunc(app.getWishlist)))
+	router.POST("/v1/me/wishlist/items", app.wrap(secure.ThenFunc(app.addWishlistItem)))
+	router.DELETE("/v1/me/wishlist/items/:product_id", app.wrap(secure.ThenFunc(app.removeWishlistItem)))
+	router.GET("/v1/me/wishlists", app.wrap(secure.ThenFunc(app.getWishlists)))
+	router.POST("/v1/me/wishlists", app.wrap(secure.ThenFunc(app.createWishlist)))
+	router.GET("/v1/me/wishlists/:id", app.wrap(secure.ThenFunc(app.getWishlist)))
+	router.PUT("/v1/me/wishlists/:id", app.wrap(secure.ThenFunc(app.updateWishlist)))
+	router.DELETE("/v1/me/wishlists/:id", app.wrap(secure.ThenFunc(app.deleteWishlist)))
+	router.POST("/v1/me/wishlists/:id/items", app.wrap(secure.ThenFunc(app.addWishlistItem)))
+	router.DELETE("/v1/me/wishlists/:id/items/:product_id", app.wrap(secure.ThenFunc(app.removeWishlistItem)))
+	router.HandlerFunc(http.MethodGet, "/v1/wishlists/shared/:token", app.getSharedWishlist)
 
 	router.HandlerFunc(http.MethodGet, "/v1/orders", app.getAllOrders)
-	router.HandlerFunc(http.MethodPost, "/v1/status", app.orderStatus)
+	router.GET("/v1/orders/:id", app.wrap(secure.ThenFunc(app.getUserOrder)))
+	router.Handler(http.MethodPost, "/v1/status", admin.ThenFunc(app.orderStatus))
 
-	router.HandlerFunc(http.MethodPost, "/image", app.uploadImage)
+	if app.config.storage.backend == "local" {
+		router.ServeFiles(app.config.storage.url+"/*filepath", http.Dir(app.config.storage.dir))
+	}
 
-	return app.enableCORS(router)
+	return app.requestID(app.enableCORS(router))
 }
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ShippingZoneInput is the body of the admin shipping zone endpoints
+type ShippingZoneInput struct {
+	Name           string   `json:"name"`
+	Countries      []string `json:"countries"`
+	PostalPrefixes []string `json:"postal_prefixes"`
+}
+
+func (in ShippingZoneInput) zone() (models.ShippingZone, error) {
+	z := models.ShippingZone{
+		Name:           strings.TrimSpace(in.Name),
+		Countries:      in.Countries,
+		PostalPrefixes: in.PostalPrefixes,
+	}
+
+	switch {
+	case z.Name == "":
+		return z, errors.New("name must not be empty")
+	case len(z.Countries) == 0:
+		return z, errors.New("countries must not be empty")
+	}
+	for _, c := range z.Countries {
+		if len(strings.TrimSpace(c)) != 2 {
+			return z, fmt.Errorf("country %q must be a two letter ISO 3166 code", c)
+		}
+	}
+
+	return z, nil
+}
+
+func readShippingZoneInput(r *http.Request) (ShippingZoneInput, error) {
+	var in ShippingZoneInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid shipping zone: %w", err)
+	}
+
+	return in, nil
+}
+
+// ShippingMethodInput is the body of the admin shipping method endpoints. Amounts are in the
+// store currency and weights in grams.
+type ShippingMethodInput struct {
+	ZoneID    int           `json:"zone_id"`
+	Name      string        `json:"name"`
+	Kind      string        `json:"kind"`
+	Price     models.Money  `json:"price"`
+	RatePerKg models.Money  `json:"rate_per_kg"`
+	MaxWeight *int          `json:"max_weight"`
+	FreeOver  *models.Money `json:"free_over"`
+	Active    *bool         `json:"active"`
+}
+
+func (in ShippingMethodInput) method(currency string) (models.ShippingMethod, error) {
+	sm := models.ShippingMethod{
+		ZoneID:    in.ZoneID,
+		Name:      strings.TrimSpace(in.Name),
+		Kind:      in.Kind,
+		Price:     in.Price,
+		RatePerKg: in.RatePerKg,
+		MaxWeight: in.MaxWeight,
+		FreeOver:  in.FreeOver,
+		Active:    in.Active == nil || *in.Active,
+	}
+
+	amounts := []*models.Money{&sm.Price, &sm.RatePerKg}
+	if sm.FreeOver != nil {
+		amounts = append(amounts, sm.FreeOver)
+	}
+	for _, amount := range amounts {
+		err := amount.In(currency)
+		if err != nil {
+			return sm, err
+		}
+		if amount.Amount < 0 {
+			return sm, errors.New("amounts must not be negative")
+		}
+	}
+
+	switch {
+	case sm.ZoneID == 0:
+		return sm, errors.New("zone_id is required")
+	case sm.Name == "":
+		return sm, errors.New("name must not be empty")
+	case sm.Kind != models.ShippingFlat && sm.Kind != models.ShippingWeight:
+		return sm, fmt.Errorf("kind must be %s or %s", models.ShippingFlat, models.ShippingWeight)
+	case sm.MaxWeight != nil && *sm.MaxWeight <= 0:
+		return sm, errors.New("max_weight must be positive")
+	}
+
+	return sm, nil
+}
+
+func readShippingMethodInput(r *http.Request) (ShippingMethodInput, error) {
+	var in ShippingMethodInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid shipping method: %w", err)
+	}
+
+	return in, nil
+}
+
+// quoteShipping tells what each shipping method available for a cart and its billing address costs
+func (app *application) quoteShipping(w http.ResponseWriter, r *http.Request) {
+	var payload CartPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	cart, err := payload.cart(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	quotes, err := app.models.DB.ShippingQuotes(&cart)
+	if errors.Is(err, models.ErrUnknownProduct) || errors.Is(err, models.ErrCouponInvalid) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, quotes, "quotes")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) getShippingZones(w http.ResponseWriter, r *http.Request) {
+	zones, err := app.models.DB.AllShippingZones()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, zones, "shipping_zones")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) createShippingZone(w http.ResponseWriter, r *http.Request) {
+	in, err := readShippingZoneInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	z, err := in.zone()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	id, err := app.models.DB.InsertShippingZone(z)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/admin/shipping-zones/%d", id))
+	app.writeShippingZone(w, id, http.StatusCreated)
+}
+
+func (app *application) updateShippingZone(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readShippingZoneInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	z, err := in.zone()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	z.ID = id
+
+	err = app.models.DB.UpdateShippingZone(z)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("shipping zone not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeShippingZone(w, id, http.StatusOK)
+}
+
+// deleteShippingZone removes a zone and every method in it
+func (app *application) deleteShippingZone(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.DeleteShippingZone(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("shipping zone not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) writeShippingZone(w http.ResponseWriter, id int, status int) {
+	z, err := app.models.DB.GetShippingZone(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, z, "shipping_zone")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) createShippingMethod(w http.ResponseWriter, r *http.Request) {
+	in, err := readShippingMethodInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	sm, err := in.method(app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	_, err = app.models.DB.GetShippingZone(sm.ZoneID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("shipping zone not found"), http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	id, err := app.models.DB.InsertShippingMethod(sm)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/admin/shipping-methods/%d", id))
+	app.writeShippingMethod(w, id, http.StatusCreated)
+}
+
+func (app *application) updateShippingMethod(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readShippingMethodInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	sm, err := in.method(app.models.DB.Currency())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	sm.ID = id
+
+	_, err = app.models.DB.GetShippingZone(sm.ZoneID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("shipping zone not found"), http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.models.DB.UpdateShippingMethod(sm)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("shipping method not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeShippingMethod(w, id, http.StatusOK)
+}
+
+func (app *application) deleteShippingMethod(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.DeleteShippingMethod(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("shipping method not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) writeShippingMethod(w http.ResponseWriter, id int, status int) {
+	sm, err := app.models.DB.GetShippingMethod(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, sm, "shipping_method")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
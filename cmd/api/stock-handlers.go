@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// StockNotifyInput is the body of the back in stock subscription endpoint
+type StockNotifyInput struct {
+	Email string `json:"email"`
+	Size  string `json:"size"`
+}
+
+// subscribeStock asks for an email when a sold out product is back in stock. Subscribers are told
+// in the order they asked, as many as there are units back in stock.
+func (app *application) subscribeStock(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var in StockNotifyInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid subscription: %w", err))
+		return
+	}
+
+	in.Email = strings.TrimSpace(in.Email)
+	addr, err := mail.ParseAddress(in.Email)
+	if err != nil || addr.Address != in.Email {
+		app.errorJSON(w, errors.New("email must be a valid email address"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = app.models.DB.SubscribeStock(id, strings.TrimSpace(in.Size), in.Email)
+	if errors.Is(err, models.ErrUnknownProduct) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if errors.Is(err, models.ErrInStock) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ok := jsonResp{
+		OK:      true,
+		Message: "you will be emailed when the product is back in stock",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, ok, "response")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// adjustStock changes the stock of a product by the delta in the body, positive for goods
+// received and negative for goods written off
+func (app *application) adjustStock(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var in struct {
+		Delta int `json:"delta"`
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&in)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid stock adjustment: %w", err))
+		return
+	}
+	if in.Delta == 0 {
+		app.errorJSON(w, errors.New("delta must not be zero"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	stock, err := app.db(r).AdjustStock(id, in.Delta)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrInsufficientStock) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, map[string]int{"product_id": id, "stock": stock}, "inventory")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
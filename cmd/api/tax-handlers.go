@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TaxRateInput is the body of the admin tax rate endpoints
+type TaxRateInput struct {
+	Name         string  `json:"name"`
+	Country      string  `json:"country"`
+	PostalPrefix string  `json:"postal_prefix"`
+	City         string  `json:"city"`
+	TaxClass     string  `json:"tax_class"`
+	Rate         float64 `json:"rate"`
+}
+
+func (in TaxRateInput) taxRate() (models.TaxRate, error) {
+	r := models.TaxRate{
+		Name:         strings.TrimSpace(in.Name),
+		Country:      strings.ToUpper(strings.TrimSpace(in.Country)),
+		PostalPrefix: strings.ToUpper(strings.ReplaceAll(in.PostalPrefix, " ", "")),
+		City:         strings.TrimSpace(in.City),
+		TaxClass:     strings.TrimSpace(in.TaxClass),
+		Rate:         in.Rate,
+	}
+	if r.TaxClass == "" {
+		r.TaxClass = models.TaxClassStandard
+	}
+
+	switch {
+	case r.Name == "":
+		return r, errors.New("name must not be empty")
+	case len(r.Country) != 2:
+		return r, errors.New("country must be a two letter ISO 3166 code")
+	case r.Rate < 0 || r.Rate > 100:
+		return r, errors.New("rate must be a percentage between 0 and 100")
+	}
+
+	return r, nil
+}
+
+func readTaxRateInput(r *http.Request) (TaxRateInput, error) {
+	var in TaxRateInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid tax rate: %w", err)
+	}
+
+	return in, nil
+}
+
+func (app *application) getTaxRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.DB.AllTaxRates()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, rates, "tax_rates")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) createTaxRate(w http.ResponseWriter, r *http.Request) {
+	in, err := readTaxRateInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	rate, err := in.taxRate()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	id, err := app.models.DB.InsertTaxRate(rate)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/admin/tax-rates/%d", id))
+	app.writeTaxRate(w, id, http.StatusCreated)
+}
+
+func (app *application) updateTaxRate(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readTaxRateInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	rate, err := in.taxRate()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	rate.ID = id
+
+	err = app.models.DB.UpdateTaxRate(rate)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("tax rate not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeTaxRate(w, id, http.StatusOK)
+}
+
+func (app *application) deleteTaxRate(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.DeleteTaxRate(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("tax rate not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) writeTaxRate(w http.ResponseWriter, id int, status int) {
+	rate, err := app.models.DB.GetTaxRate(id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, rate, "tax_rate")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
@@ -98,6 +98,16 @@ func (app *application) signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a cart filled before signing in carries over to the user's cart
+	if owner, err := app.cartOwner(r); err != nil {
+		app.logger.Print(err)
+	} else if owner.Token != "" {
+		err = app.models.DB.MergeCart(owner.Token, validUser.ID)
+		if err != nil {
+			app.logger.Print(err)
+		}
+	}
+
 	var claim jwt.Claims
 	claim.Subject = fmt.Sprint(validUser.ID)
 	claim.Issued = jwt.NewNumericTime(time.Now())
@@ -105,6 +115,7 @@ func (app *application) signin(w http.ResponseWriter, r *http.Request) {
 	claim.Expires = jwt.NewNumericTime(time.Now().Add(24 * time.Hour))
 	claim.Issuer = "mydomain.com"
 	claim.Audiences = []string{"mydomain.com"}
+	claim.Set = map[string]interface{}{"access_level": validUser.AccessLevel}
 
 	var token Token
 
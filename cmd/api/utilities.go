@@ -1,7 +1,9 @@
 package main
 
 import (
+	"ecom-api/models"
 	"encoding/json"
+	"errors"
 	"net/http"
 )
 
@@ -22,7 +24,12 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error) {
+func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
+	statusCode := http.StatusBadRequest
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
 	type jsonError struct {
 		Message string `json:"message"`
 	}
@@ -31,5 +38,17 @@ func (app *application) errorJSON(w http.ResponseWriter, err error) {
 		Message: err.Error(),
 	}
 
-	app.writeJSON(w, http.StatusBadRequest, theError, "error")
+	app.writeJSON(w, statusCode, theError, "error")
+}
+
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+	app.errorJSON(w, errors.New("not found"), http.StatusNotFound)
+}
+
+// db returns the models acting on behalf of the signed in user, so that changes are audited
+func (app *application) db(r *http.Request) *models.DBModel {
+	userID, _ := r.Context().Value(userIDKey).(int)
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+
+	return app.models.DB.WithActor(userID, requestID)
 }
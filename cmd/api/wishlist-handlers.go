@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"ecom-api/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// WishlistInput is the body of the endpoints creating and updating wishlists. Public turns
+// the share link of the list on or off.
+type WishlistInput struct {
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+}
+
+func readWishlistInput(r *http.Request) (WishlistInput, error) {
+	var in WishlistInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid wishlist: %w", err)
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		in.Name = models.DefaultWishlistName
+	}
+
+	return in, nil
+}
+
+// WishlistItemInput is the body of the endpoints saving a product to a wishlist
+type WishlistItemInput struct {
+	ProductID int    `json:"product_id"`
+	Size      string `json:"size"`
+}
+
+func readWishlistItemInput(r *http.Request) (WishlistItemInput, error) {
+	var in WishlistItemInput
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&in)
+	if err != nil {
+		return in, fmt.Errorf("invalid wishlist item: %w", err)
+	}
+
+	return in, nil
+}
+
+// signedInUser returns the id of the user a secured request was made by
+func signedInUser(r *http.Request) int {
+	userID, _ := r.Context().Value(userIDKey).(int)
+	return userID
+}
+
+// wishlistParam returns the wishlist named by the :id parameter, or 0, the default wishlist,
+// on the routes that have none
+func wishlistParam(r *http.Request) (int, error) {
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "" {
+		return 0, nil
+	}
+
+	return idParam(r)
+}
+
+func (app *application) getWishlists(w http.ResponseWriter, r *http.Request) {
+	lists, err := app.models.DB.Wishlists(signedInUser(r))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, lists, "wishlists")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// getWishlist returns the wishlist of the :id parameter, or the default wishlist
+func (app *application) getWishlist(w http.ResponseWriter, r *http.Request) {
+	id, err := wishlistParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var list *models.Wishlist
+	if id == 0 {
+		list, err = app.models.DB.DefaultWishlist(signedInUser(r))
+	} else {
+		list, err = app.models.DB.GetWishlist(signedInUser(r), id)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("wishlist not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, list, "wishlist")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+// getSharedWishlist returns a wishlist by its share token, for anyone the owner gave the link to
+func (app *application) getSharedWishlist(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	list, err := app.models.DB.SharedWishlist(token)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("wishlist not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, list, "wishlist")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
+
+func (app *application) createWishlist(w http.ResponseWriter, r *http.Request) {
+	in, err := readWishlistInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID := signedInUser(r)
+
+	id, err := app.models.DB.InsertWishlist(models.Wishlist{UserID: userID, Name: in.Name}, in.Public)
+	if errors.Is(err, models.ErrWishlistExists) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/me/wishlists/%d", id))
+	app.writeWishlist(w, userID, id, http.StatusCreated)
+}
+
+func (app *application) updateWishlist(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readWishlistInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID := signedInUser(r)
+
+	err = app.models.DB.UpdateWishlist(models.Wishlist{ID: id, UserID: userID, Name: in.Name}, in.Public)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("wishlist not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrWishlistExists) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeWishlist(w, userID, id, http.StatusOK)
+}
+
+func (app *application) deleteWishlist(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.DB.DeleteWishlist(signedInUser(r), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("wishlist not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addWishlistItem saves a product to the wishlist of the :id parameter, or to the default wishlist
+func (app *application) addWishlistItem(w http.ResponseWriter, r *http.Request) {
+	id, err := wishlistParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	in, err := readWishlistItemInput(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID := signedInUser(r)
+
+	id, err = app.models.DB.AddWishlistItem(userID, id, in.ProductID, in.Size)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("wishlist not found"), http.StatusNotFound)
+		return
+	} else if errors.Is(err, models.ErrUnknownProduct) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeWishlist(w, userID, id, http.StatusOK)
+}
+
+// removeWishlistItem takes the product of the :product_id parameter, in the size of the size
+// query parameter, off the wishlist of the :id parameter, or off the default wishlist
+func (app *application) removeWishlistItem(w http.ResponseWriter, r *http.Request) {
+	id, err := wishlistParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	productID, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("product_id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid product_id parameter"))
+		return
+	}
+
+	userID := signedInUser(r)
+
+	id, err = app.models.DB.RemoveWishlistItem(userID, id, productID, r.URL.Query().Get("size"))
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("product not on wishlist"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeWishlist(w, userID, id, http.StatusOK)
+}
+
+func (app *application) writeWishlist(w http.ResponseWriter, userID, id int, status int) {
+	list, err := app.models.DB.GetWishlist(userID, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.writeJSON(w, status, list, "wishlist")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+}
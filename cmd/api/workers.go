@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"ecom-api/mailer"
+	"ecom-api/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// every runs job in the background at the given interval until the process exits
+func (app *application) every(name string, interval time.Duration, job func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := job()
+			if err != nil {
+				app.logger.Printf("%s: %v", name, err)
+			}
+		}
+	}()
+}
+
+// purgeArchivedProducts permanently deletes products that have been archived longer than the retention period
+func (app *application) purgeArchivedProducts() error {
+	keys, err := app.models.DB.PurgeArchivedProducts(time.Now().Add(-app.config.purge.after))
+	app.deleteImages(context.Background(), keys)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		app.logger.Println("purged archived products, images removed:", len(keys))
+	}
+
+	return nil
+}
+
+// purgeIdempotencyKeys deletes the stored responses of idempotent requests once they can no longer be replayed
+func (app *application) purgeIdempotencyKeys() error {
+	n, err := app.models.DB.PurgeIdempotencyKeys(time.Now().Add(-idempotencyTTL))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Println("purged idempotency keys:", n)
+	}
+
+	return nil
+}
+
+// refreshCopurchases counts again how often products were bought together, for the related
+// products of each product
+func (app *application) refreshCopurchases() error {
+	n, err := app.models.DB.RefreshCopurchases()
+	if err != nil {
+		return err
+	}
+
+	app.logger.Println("co-purchase pairs counted:", n)
+
+	return nil
+}
+
+// remindAbandonedCarts mails the owners of carts left idle, up to the configured number of
+// reminders per cart
+func (app *application) remindAbandonedCarts() error {
+	cfg := app.config.abandoned
+	now := time.Now()
+
+	carts, err := app.models.DB.AbandonedCarts(now.Add(-cfg.after), now.Add(-cfg.gap), cfg.reminders)
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	for _, cart := range carts {
+		var items strings.Builder
+		for _, item := range cart.Items {
+			fmt.Fprintf(&items, "  %d x %s\n", item.Quantity, item.Title)
+		}
+
+		msg := mailer.Message{
+			To:      cart.Email,
+			Subject: "You left something in your cart",
+			Body: fmt.Sprintf("Your cart is waiting for you:\n\n%s\nPick up where you left off:\n%s\n\n"+
+				"To stop these reminders, follow this link:\n%s\n",
+				items.String(), app.restoreURL(cart), app.unsubscribeURL(cart.Email)),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = app.mailer.Send(ctx, msg)
+		cancel()
+		if err != nil {
+			app.logger.Printf("remind cart %d: %v", cart.ID, err)
+			continue
+		}
+
+		err = app.models.DB.RecordCartReminder(cart.ID, cart.Email)
+		if err != nil {
+			app.logger.Printf("record reminder of cart %d: %v", cart.ID, err)
+			continue
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		app.logger.Println("abandoned cart reminders sent:", sent)
+	}
+
+	return nil
+}
+
+// stockNotificationBatch is how many back in stock emails sendStockNotifications sends per run
+const stockNotificationBatch = 100
+
+// sendStockNotifications emails the subscribers queued when products came back in stock
+func (app *application) sendStockNotifications() error {
+	notifications, err := app.models.DB.QueuedStockNotifications(stockNotificationBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range notifications {
+		// without a URL to send customers to, the email goes without a link
+		var link string
+		if app.config.storefrontURL != "" {
+			link = fmt.Sprintf("%s/product/%d\n\n", strings.TrimRight(app.config.storefrontURL, "/"), n.ProductID)
+		} else if app.config.publicURL != "" {
+			link = fmt.Sprintf("%s/v1/product/%d\n\n", strings.TrimRight(app.config.publicURL, "/"), n.ProductID)
+		}
+
+		title := n.Title
+		if n.Size != "" {
+			title += " (" + n.Size + ")"
+		}
+
+		msg := mailer.Message{
+			To:      n.Email,
+			Subject: n.Title + " is back in stock",
+			Body: fmt.Sprintf("Good news: %s is back in stock, while it lasts.\n\n%s"+
+				"You asked to be told on %s. This is the only email you will get about it.\n",
+				title, link, n.CreatedAt.Format("January 2, 2006")),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = app.mailer.Send(ctx, msg)
+		cancel()
+		if err != nil {
+			app.logger.Printf("stock notification %d: %v", n.ID, err)
+			continue
+		}
+
+		err = app.models.DB.StockNotificationSent(n.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// imageImportBatch is how many queued import images importQueuedImages downloads per run
+const imageImportBatch = 20
+
+// importQueuedImages downloads the images queued by bulk imports into the product galleries, on
+// behalf of the admin who ran the import
+func (app *application) importQueuedImages() error {
+	imports, err := app.models.DB.QueuedImageImports(imageImportBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range imports {
+		db := app.models.DB.WithActor(i.ActorID, i.RequestID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		data, err := downloadImage(ctx, i.Source)
+		if err == nil {
+			_, err = app.storeProductImage(ctx, db, models.ProductImage{ProductID: i.ProductID, SortOrder: -1, Source: i.Source}, data)
+		}
+		cancel()
+
+		failure := ""
+		if err != nil {
+			failure = err.Error()
+			app.logger.Printf("import image %s for product %d: %v", i.Source, i.ProductID, err)
+		}
+
+		err = app.models.DB.ImageImportDone(i.ID, failure)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,11 +1,13 @@
 module ecom-api
 
-go 1.16
+go 1.22.2
 
 require (
-	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/justinas/alice v1.2.0 // indirect
-	github.com/lib/pq v1.10.4 // indirect
-	github.com/pascaldekloe/jwt v1.10.0 // indirect
-	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871 // indirect
+	github.com/HugoSmits86/nativewebp v0.9.3
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/justinas/alice v1.2.0
+	github.com/lib/pq v1.10.4
+	github.com/pascaldekloe/jwt v1.10.0
+	golang.org/x/crypto v0.23.0
+	golang.org/x/image v0.24.0
 )
@@ -0,0 +1,89 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+var (
+	// ErrUnsupportedType is returned for uploads that are not one of the accepted image formats
+	ErrUnsupportedType = errors.New("unsupported image type")
+	// ErrInvalidImage wraps every other reason an upload is rejected
+	ErrInvalidImage = errors.New("invalid image")
+)
+
+// extensions maps the accepted content types to the file extension they are stored with
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Limits bounds the uploads that are accepted
+type Limits struct {
+	MaxBytes  int64
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+}
+
+// DefaultLimits are the limits applied to product images
+var DefaultLimits = Limits{
+	MaxBytes:  10 * 1024 * 1024,
+	MinWidth:  100,
+	MinHeight: 100,
+	MaxWidth:  6000,
+	MaxHeight: 6000,
+}
+
+// Info describes a validated image
+type Info struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+// Inspect sniffs the content type of data from its magic bytes and checks it against the limits.
+// The file name and content type sent by the client are never trusted.
+func Inspect(data []byte, limits Limits) (Info, error) {
+	var info Info
+
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return info, fmt.Errorf("%w: larger than %d bytes", ErrInvalidImage, limits.MaxBytes)
+	}
+
+	info.ContentType = http.DetectContentType(data)
+
+	ext, ok := extensions[info.ContentType]
+	if !ok {
+		return info, ErrUnsupportedType
+	}
+	info.Ext = ext
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return info, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	info.Width = cfg.Width
+	info.Height = cfg.Height
+
+	if info.Width < limits.MinWidth || info.Height < limits.MinHeight {
+		return info, fmt.Errorf("%w: must be at least %dx%d pixels", ErrInvalidImage, limits.MinWidth, limits.MinHeight)
+	}
+
+	if limits.MaxWidth > 0 && info.Width > limits.MaxWidth || limits.MaxHeight > 0 && info.Height > limits.MaxHeight {
+		return info, fmt.Errorf("%w: must be at most %dx%d pixels", ErrInvalidImage, limits.MaxWidth, limits.MaxHeight)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,98 @@
+package imaging
+
+import (
+	"bytes"
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+)
+
+// Size is a named bounding box that variants are resized to fit in
+type Size struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Sizes are the variants generated for every product image, from small to large.
+// Images are never upscaled: the first size the original fits in is the last one generated.
+var Sizes = []Size{
+	{Name: "thumbnail", MaxWidth: 150, MaxHeight: 150},
+	{Name: "small", MaxWidth: 400, MaxHeight: 400},
+	{Name: "medium", MaxWidth: 800, MaxHeight: 800},
+	{Name: "large", MaxWidth: 1600, MaxHeight: 1600},
+}
+
+// Variant is an encoded, resized copy of an image
+type Variant struct {
+	Name        string
+	Format      string
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Variants decodes data and returns a JPEG and a WebP copy of it for each of the sizes
+func Variants(data []byte, sizes []Size) ([]Variant, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+
+	var variants []Variant
+
+	for _, size := range sizes {
+		width, height := fit(bounds.Dx(), bounds.Dy(), size.MaxWidth, size.MaxHeight)
+
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+		var jpg bytes.Buffer
+		err := jpeg.Encode(&jpg, dst, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return nil, err
+		}
+
+		var webp bytes.Buffer
+		err = nativewebp.Encode(&webp, dst, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants,
+			Variant{Name: size.Name, Format: "jpeg", ContentType: "image/jpeg", Ext: ".jpg", Width: width, Height: height, Data: jpg.Bytes()},
+			Variant{Name: size.Name, Format: "webp", ContentType: "image/webp", Ext: ".webp", Width: width, Height: height, Data: webp.Bytes()},
+		)
+
+		if width == bounds.Dx() && height == bounds.Dy() {
+			break
+		}
+	}
+
+	return variants, nil
+}
+
+// fit scales width and height down to fit in the bounding box, keeping the aspect ratio
+func fit(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+
+	if width*maxHeight > height*maxWidth {
+		return maxWidth, max1(height * maxWidth / width)
+	}
+
+	return max1(width * maxHeight / height), maxHeight
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,4 @@
+alter table category
+    add column parent_id integer references category (id) on delete set null;
+
+create index category_parent_id_idx on category (parent_id);
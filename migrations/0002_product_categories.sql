@@ -0,0 +1,8 @@
+delete from products_category a
+    using products_category b
+    where a.product_id = b.product_id
+      and a.category_id = b.category_id
+      and a.id > b.id;
+
+alter table products_category
+    add constraint products_category_product_category_key unique (product_id, category_id);
@@ -0,0 +1,5 @@
+-- product images used to be stored as absolute paths below the local img/ directory;
+-- keep only the part relative to that directory, which is the key in the local store
+update products
+    set image = regexp_replace(image, '^.*/img/', '')
+    where image like '%/img/%';
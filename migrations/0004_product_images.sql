@@ -0,0 +1,31 @@
+create table product_images (
+    id         serial primary key,
+    product_id integer   not null references products (id) on delete cascade,
+    image_key  text      not null,
+    alt_text   text      not null default '',
+    sort_order integer   not null default 0,
+    is_primary boolean   not null default false,
+    width      integer   not null default 0,
+    height     integer   not null default 0,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now()
+);
+
+create index product_images_product_id_idx on product_images (product_id, sort_order);
+create unique index product_images_primary_idx on product_images (product_id) where is_primary;
+
+create table product_image_variants (
+    id        serial primary key,
+    image_id  integer not null references product_images (id) on delete cascade,
+    name      text    not null,
+    format    text    not null,
+    image_key text    not null,
+    width     integer not null,
+    height    integer not null
+);
+
+create index product_image_variants_image_id_idx on product_image_variants (image_id);
+
+-- the single image of existing products becomes the primary image of their gallery
+insert into product_images (product_id, image_key, is_primary)
+    select id, image, true from products where image <> '';
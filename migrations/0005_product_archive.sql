@@ -0,0 +1,4 @@
+alter table products
+    add column deleted_at timestamp;
+
+create index products_deleted_at_idx on products (deleted_at) where deleted_at is not null;
@@ -0,0 +1,2 @@
+alter table products
+    add column version integer not null default 1;
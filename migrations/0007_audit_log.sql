@@ -0,0 +1,14 @@
+create table audit_log (
+    id         serial primary key,
+    entity     text      not null,
+    entity_id  integer   not null,
+    action     text      not null,
+    actor_id   integer references users (id) on delete set null,
+    request_id text      not null default '',
+    before     jsonb,
+    after      jsonb,
+    diff       jsonb     not null default '{}',
+    created_at timestamp not null default now()
+);
+
+create index audit_log_entity_idx on audit_log (entity, entity_id, id);
@@ -0,0 +1,3 @@
+alter table products add column sku text unique;
+
+alter table product_images add column source text not null default '';
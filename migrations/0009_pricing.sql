@@ -0,0 +1,24 @@
+alter table products
+    add column compare_at_price integer,
+    add column sale_price       integer,
+    add column sale_starts_at   timestamp,
+    add column sale_ends_at     timestamp;
+
+create table discounts (
+    id          serial primary key,
+    name        text      not null,
+    kind        text      not null check (kind in ('percent', 'fixed')),
+    value       integer   not null check (value > 0),
+    product_id  integer references products (id) on delete cascade,
+    category_id integer references category (id) on delete cascade,
+    starts_at   timestamp,
+    ends_at     timestamp,
+    active      boolean   not null default true,
+    created_at  timestamp not null default now(),
+    updated_at  timestamp not null default now(),
+    check ((product_id is null) <> (category_id is null)),
+    check (kind <> 'percent' or value <= 100)
+);
+
+create index discounts_product_idx on discounts (product_id) where product_id is not null;
+create index discounts_category_idx on discounts (category_id) where category_id is not null;
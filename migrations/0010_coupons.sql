@@ -0,0 +1,32 @@
+create table coupons (
+    id             serial primary key,
+    code           text      not null unique,
+    kind           text      not null check (kind in ('percent', 'fixed', 'free_shipping')),
+    value          integer   not null default 0 check (value >= 0),
+    min_order      integer   not null default 0,
+    product_ids    integer[] not null default '{}',
+    category_ids   integer[] not null default '{}',
+    usage_limit    integer,
+    per_user_limit integer,
+    starts_at      timestamp,
+    ends_at        timestamp,
+    active         boolean   not null default true,
+    created_at     timestamp not null default now(),
+    updated_at     timestamp not null default now()
+);
+
+create table coupon_redemptions (
+    id         serial primary key,
+    coupon_id  integer   not null references coupons (id),
+    order_id   integer   not null references orders (id) on delete cascade,
+    user_id    integer,
+    discount   integer   not null,
+    created_at timestamp not null default now()
+);
+
+create index coupon_redemptions_coupon_idx on coupon_redemptions (coupon_id, user_id);
+
+alter table orders
+    add column coupon_code   text,
+    add column discount      integer not null default 0,
+    add column free_shipping boolean not null default false;
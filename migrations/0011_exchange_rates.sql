@@ -0,0 +1,5 @@
+create table exchange_rates (
+    currency   char(3)        primary key,
+    rate       numeric(18, 8) not null check (rate > 0),
+    updated_at timestamp      not null default now()
+);
@@ -0,0 +1,24 @@
+alter table products
+    add column tax_class text not null default 'standard';
+
+create table tax_rates (
+    id            serial primary key,
+    name          text          not null,
+    country       char(2)       not null,
+    postal_prefix text          not null default '',
+    city          text          not null default '',
+    tax_class     text          not null default 'standard',
+    rate          numeric(7, 4) not null check (rate >= 0),
+    created_at    timestamp     not null default now(),
+    updated_at    timestamp     not null default now()
+);
+
+create index tax_rates_country_idx on tax_rates (country);
+
+alter table billing_info
+    add column country char(2);
+
+alter table orders
+    add column tax          integer not null default 0,
+    add column tax_lines    jsonb   not null default '[]',
+    add column tax_included boolean not null default false;
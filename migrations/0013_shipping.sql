@@ -0,0 +1,35 @@
+alter table products
+    add column weight integer not null default 0 check (weight >= 0),
+    add column length integer not null default 0 check (length >= 0),
+    add column width  integer not null default 0 check (width >= 0),
+    add column height integer not null default 0 check (height >= 0);
+
+create table shipping_zones (
+    id              serial primary key,
+    name            text      not null,
+    countries       text[]    not null,
+    postal_prefixes text[]    not null default '{}',
+    created_at      timestamp not null default now(),
+    updated_at      timestamp not null default now()
+);
+
+create table shipping_methods (
+    id          serial primary key,
+    zone_id     integer   not null references shipping_zones (id) on delete cascade,
+    name        text      not null,
+    kind        text      not null check (kind in ('flat', 'weight')),
+    price       integer   not null default 0 check (price >= 0),
+    rate_per_kg integer   not null default 0 check (rate_per_kg >= 0),
+    max_weight  integer,
+    free_over   integer,
+    active      boolean   not null default true,
+    created_at  timestamp not null default now(),
+    updated_at  timestamp not null default now()
+);
+
+create index shipping_methods_zone_idx on shipping_methods (zone_id);
+
+alter table orders
+    add column shipping_method_id integer references shipping_methods (id) on delete set null,
+    add column shipping_method    text,
+    add column shipping           integer not null default 0;
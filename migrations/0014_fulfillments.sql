@@ -0,0 +1,18 @@
+create table fulfillments (
+    id              serial primary key,
+    order_id        integer   not null references orders (id) on delete cascade,
+    carrier         text      not null,
+    tracking_number text      not null default '',
+    tracking_url    text      not null default '',
+    shipped_at      timestamp not null,
+    created_at      timestamp not null default now()
+);
+
+create index fulfillments_order_idx on fulfillments (order_id);
+
+create table fulfillment_items (
+    fulfillment_id integer not null references fulfillments (id) on delete cascade,
+    line           integer not null check (line >= 0),
+    quantity       integer not null check (quantity > 0),
+    primary key (fulfillment_id, line)
+);
@@ -0,0 +1,17 @@
+create table carts (
+    id         serial primary key,
+    user_id    integer unique references users (id) on delete cascade,
+    token      text unique,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now(),
+    check (user_id is not null or token is not null)
+);
+
+create table cart_items (
+    cart_id    integer   not null references carts (id) on delete cascade,
+    product_id integer   not null references products (id) on delete cascade,
+    size       text      not null default '',
+    quantity   integer   not null check (quantity > 0),
+    added_at   timestamp not null default now(),
+    primary key (cart_id, product_id, size)
+);
@@ -0,0 +1,9 @@
+alter table orders
+    alter column user_id drop not null,
+    add column email             text,
+    add column lookup_token_hash text unique;
+
+create index orders_guest_email_idx on orders (lower(email)) where user_id is null;
+
+alter table billing_info
+    alter column user_id drop not null;
@@ -0,0 +1,12 @@
+create table idempotency_keys (
+    key          text      not null,
+    path         text      not null,
+    request_hash text      not null,
+    status       integer,
+    header       jsonb,
+    body         bytea,
+    created_at   timestamp not null default now(),
+    primary key (key, path)
+);
+
+create index idempotency_keys_created_idx on idempotency_keys (created_at);
@@ -0,0 +1,19 @@
+alter table carts
+    add column email            text,
+    add column reminders_sent   integer not null default 0,
+    add column last_reminded_at timestamp;
+
+create table cart_reminders (
+    id       serial primary key,
+    cart_id  integer   references carts (id) on delete set null,
+    email    text      not null,
+    sent_at  timestamp not null default now(),
+    order_id integer   references orders (id) on delete set null
+);
+
+create index cart_reminders_cart_idx on cart_reminders (cart_id);
+
+create table email_unsubscribes (
+    email           text primary key,
+    unsubscribed_at timestamp not null default now()
+);
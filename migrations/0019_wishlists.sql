@@ -0,0 +1,22 @@
+create table wishlists (
+    id          serial primary key,
+    user_id     integer   not null references users (id) on delete cascade,
+    name        text      not null,
+    share_token text unique,
+    created_at  timestamp not null default now(),
+    updated_at  timestamp not null default now(),
+    unique (user_id, name)
+);
+
+create table wishlist_items (
+    wishlist_id   integer   not null references wishlists (id) on delete cascade,
+    product_id    integer   not null references products (id) on delete cascade,
+    size          text      not null default '',
+    added_price   integer   not null,
+    back_in_stock boolean   not null default false,
+    price_dropped boolean   not null default false,
+    added_at      timestamp not null default now(),
+    primary key (wishlist_id, product_id, size)
+);
+
+create index wishlist_items_product_idx on wishlist_items (product_id);
@@ -0,0 +1,15 @@
+create table stock_notifications (
+    id         serial primary key,
+    product_id integer   not null references products (id) on delete cascade,
+    size       text      not null default '',
+    email      text      not null,
+    created_at timestamp not null default now(),
+    queued_at  timestamp,
+    sent_at    timestamp
+);
+
+-- one waiting subscription per product, size and email
+create unique index stock_notifications_waiting_idx on stock_notifications (product_id, size, lower(email))
+    where sent_at is null;
+
+create index stock_notifications_queued_idx on stock_notifications (queued_at) where queued_at is not null and sent_at is null;
@@ -0,0 +1,31 @@
+create table reviews (
+    id            serial primary key,
+    product_id    integer   not null references products (id) on delete cascade,
+    user_id       integer   not null references users (id) on delete cascade,
+    rating        smallint  not null check (rating between 1 and 5),
+    title         text      not null,
+    body          text      not null default '',
+    status        text      not null default 'pending' check (status in ('pending', 'approved', 'rejected')),
+    helpful_count integer   not null default 0,
+    created_at    timestamp not null default now(),
+    moderated_at  timestamp,
+    unique (product_id, user_id)
+);
+
+create index reviews_product_idx on reviews (product_id, status);
+
+create table review_votes (
+    review_id  integer   not null references reviews (id) on delete cascade,
+    user_id    integer   not null references users (id) on delete cascade,
+    created_at timestamp not null default now(),
+    primary key (review_id, user_id)
+);
+
+-- the average rating and count of approved reviews, kept up to date as reviews are moderated
+create table product_ratings (
+    product_id integer      primary key references products (id) on delete cascade,
+    average    numeric(3,2) not null,
+    count      integer      not null
+);
+
+create index product_ratings_sort_idx on product_ratings (average desc, count desc);
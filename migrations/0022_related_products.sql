@@ -0,0 +1,20 @@
+-- how many orders held both products, worked out from past orders by a background job. Every
+-- pair is stored both ways round, so that the products bought with one are read by its id.
+create table product_copurchases (
+    product_id integer not null references products (id) on delete cascade,
+    related_id integer not null references products (id) on delete cascade,
+    orders     integer not null,
+    primary key (product_id, related_id)
+);
+
+create index product_copurchases_rank_idx on product_copurchases (product_id, orders desc);
+
+-- products an admin pinned to the top of the related products of another, or hid from them
+create table related_product_overrides (
+    product_id integer not null references products (id) on delete cascade,
+    related_id integer not null references products (id) on delete cascade,
+    hidden     boolean not null default false,
+    sort_order integer not null default 0,
+    primary key (product_id, related_id),
+    check (product_id <> related_id)
+);
@@ -0,0 +1,26 @@
+-- categories are only ever managed in the database, so a parent that would make a category its
+-- own ancestor is rejected here, before it can send the recursive tree queries round in circles
+create function category_reject_cycle() returns trigger as $$
+begin
+    if new.parent_id is null then
+        return new;
+    end if;
+
+    if exists (
+        with recursive ancestors as (
+            select new.parent_id as id
+            union
+            select c.parent_id from category c join ancestors a on (c.id = a.id) where c.parent_id is not null
+        )
+        select 1 from ancestors where id = new.id
+    ) then
+        raise exception 'category % cannot be its own ancestor', new.id using errcode = 'check_violation';
+    end if;
+
+    return new;
+end;
+$$ language plpgsql;
+
+create trigger category_reject_cycle
+    before insert or update of parent_id on category
+    for each row execute function category_reject_cycle();
@@ -0,0 +1,14 @@
+-- images of bulk imports waiting to be downloaded from their URLs by a background job, so that
+-- an import does not wait on other servers. Failed downloads are kept with their error.
+create table image_imports (
+    id         serial primary key,
+    product_id integer   not null references products (id) on delete cascade,
+    source     text      not null,
+    actor_id   integer,
+    request_id text      not null default '',
+    error      text      not null default '',
+    created_at timestamp not null default now(),
+    done_at    timestamp
+);
+
+create unique index image_imports_waiting_idx on image_imports (product_id, source) where done_at is null;
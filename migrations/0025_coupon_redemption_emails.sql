@@ -0,0 +1,4 @@
+-- guests have no user to count their uses of a coupon by, so their email is kept instead
+alter table coupon_redemptions add column email text;
+
+create index coupon_redemptions_email_idx on coupon_redemptions (coupon_id, lower(email)) where email is not null;
@@ -0,0 +1,4 @@
+-- orders are placed pending, until they are paid or cancelled
+update orders set status = 'pending' where coalesce(status, '') = '';
+
+alter table orders alter column status set default 'pending';
@@ -0,0 +1,8 @@
+-- idempotency keys are scoped to whoever sent them, a user or a cart token, rather than to the
+-- path. Keys are only kept for a day, so the ones stored so far are dropped.
+delete from idempotency_keys;
+
+alter table idempotency_keys drop constraint idempotency_keys_pkey;
+alter table idempotency_keys drop column path;
+alter table idempotency_keys add column owner text not null default '';
+alter table idempotency_keys add primary key (owner, key);
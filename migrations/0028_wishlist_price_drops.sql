@@ -0,0 +1,3 @@
+-- whether the price of a wishlist item dropped is worked out when the list is read, from the
+-- price of the product then
+alter table wishlist_items drop column price_dropped;
@@ -0,0 +1,327 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/lib/pq"
+	"reflect"
+	"time"
+)
+
+// ErrNotRevertible is returned when an audit entry holds no product state to go back to
+var ErrNotRevertible = errors.New("revision cannot be reverted to")
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// WithActor returns a copy of the model that records the given user and request
+// in the audit log for every change it makes
+func (m *DBModel) WithActor(actorID int, requestID string) *DBModel {
+	c := *m
+	c.actorID = actorID
+	c.requestID = requestID
+	return &c
+}
+
+// ProductHistory returns the audit log of a product, newest first
+func (m *DBModel) ProductHistory(productID int) ([]*AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, entity_id, action, coalesce(actor_id, 0), request_id, before, after, diff, created_at
+			from audit_log
+			where entity = 'product' and entity_id = $1
+			order by id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var e AuditEntry
+		var before, after, diff []byte
+
+		err := rows.Scan(
+			&e.ID,
+			&e.EntityID,
+			&e.Action,
+			&e.ActorID,
+			&e.RequestID,
+			&before,
+			&after,
+			&diff,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		e.Before = nullJSON(before)
+		e.After = nullJSON(after)
+		e.Diff = nullJSON(diff)
+
+		entries = append(entries, &e)
+	}
+
+	return entries, rows.Err()
+}
+
+// RevertProduct puts a product back in the state recorded after the given audit entry, provided the
+// product is still at the given version. Images are not reverted, as their files may be gone.
+func (m *DBModel) RevertProduct(productID, auditID, version int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var after []byte
+	err := m.DB.QueryRowContext(ctx, `select after from audit_log where id = $1 and entity = 'product' and entity_id = $2`,
+		auditID, productID).Scan(&after)
+	if err != nil {
+		return err
+	}
+	if after == nil {
+		return ErrNotRevertible
+	}
+
+	var state struct {
+		Title       string        `json:"title"`
+		Price       int           `json:"price"`
+		Size        []string      `json:"size"`
+		Description string        `json:"description"`
+		Stock       int           `json:"stock"`
+		Shipping    bool          `json:"shipping"`
+		DeletedAt   *snapshotTime `json:"deleted_at"`
+		Categories  []int         `json:"categories"`
+
+		CompareAtPrice *int          `json:"compare_at_price"`
+		SalePrice      *int          `json:"sale_price"`
+		SaleStartsAt   *snapshotTime `json:"sale_starts_at"`
+		SaleEndsAt     *snapshotTime `json:"sale_ends_at"`
+		TaxClass       *string       `json:"tax_class"`
+		Weight         *int          `json:"weight"`
+		Length         *int          `json:"length"`
+		Width          *int          `json:"width"`
+		Height         *int          `json:"height"`
+	}
+
+	err = json.Unmarshal(after, &state)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := productSnapshot(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	var stockBefore int
+	err = tx.QueryRowContext(ctx, `select stock from products where id = $1`, productID).Scan(&stockBefore)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	stmt := `update products set title = $1, price = $2, size = $3, description = $4, stock = $5, shipping = $6,
+				deleted_at = $7, updated_at = $8, compare_at_price = $11, sale_price = $12, sale_starts_at = $13,
+				sale_ends_at = $14, tax_class = coalesce($15, tax_class), weight = coalesce($16, weight),
+				length = coalesce($17, length), width = coalesce($18, width), height = coalesce($19, height),
+				version = version + 1
+			where id = $9 and version = $10`
+
+	err = execOne(ctx, tx, stmt,
+		state.Title,
+		state.Price,
+		pq.Array(state.Size),
+		state.Description,
+		state.Stock,
+		state.Shipping,
+		state.DeletedAt.value(),
+		time.Now(),
+		productID,
+		version,
+		state.CompareAtPrice,
+		state.SalePrice,
+		state.SaleStartsAt.value(),
+		state.SaleEndsAt.value(),
+		state.TaxClass,
+		state.Weight,
+		state.Length,
+		state.Width,
+		state.Height,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m.versionError(ctx, productID)
+	} else if err != nil {
+		return err
+	}
+
+	// categories deleted since the revision cannot be gone back to
+	err = checkCategories(ctx, tx, state.Categories)
+	if err != nil {
+		return err
+	}
+
+	err = replaceCategories(ctx, tx, productID, state.Categories)
+	if err != nil {
+		return err
+	}
+
+	err = m.audit(ctx, tx, productID, "revert", before)
+	if err != nil {
+		return err
+	}
+
+	err = m.productChanged(ctx, tx, productID, stockBefore)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// audit records a change to a product, comparing the state before it with the current state.
+// It must run in the transaction that made the change.
+func (m *DBModel) audit(ctx context.Context, tx dbtx, productID int, action string, before []byte) error {
+	after, err := productSnapshot(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	diff, err := diffJSON(before, after)
+	if err != nil {
+		return err
+	}
+
+	var actorID interface{}
+	if m.actorID > 0 {
+		actorID = m.actorID
+	}
+
+	stmt := `insert into audit_log (entity, entity_id, action, actor_id, request_id, before, after, diff, created_at)
+			values ('product', $1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		productID,
+		action,
+		actorID,
+		m.requestID,
+		nullableJSON(before),
+		nullableJSON(after),
+		string(diff),
+		time.Now(),
+	)
+
+	return err
+}
+
+// productSnapshot returns the stored state of a product as JSON, or nil if it does not exist
+func productSnapshot(ctx context.Context, tx dbtx, productID int) ([]byte, error) {
+	query := `select to_jsonb(p) || jsonb_build_object(
+				'categories', coalesce((select jsonb_agg(category_id order by category_id)
+					from products_category where product_id = p.id), '[]'::jsonb),
+				'images', coalesce((select jsonb_agg(image_key order by sort_order, id)
+					from product_images where product_id = p.id), '[]'::jsonb)
+			)
+			from products p
+			where p.id = $1`
+
+	var snapshot []byte
+	err := tx.QueryRowContext(ctx, query, productID).Scan(&snapshot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return snapshot, err
+}
+
+// diffJSON returns the top level fields that differ between two JSON objects, as {"field": {"from": .., "to": ..}}
+func diffJSON(before, after []byte) ([]byte, error) {
+	var b, a map[string]interface{}
+
+	if before != nil {
+		err := json.Unmarshal(before, &b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		err := json.Unmarshal(after, &a)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	diff := make(map[string]map[string]interface{})
+
+	for key := range b {
+		if key == "updated_at" || key == "version" {
+			continue
+		}
+		if !reflect.DeepEqual(b[key], a[key]) {
+			diff[key] = map[string]interface{}{"from": b[key], "to": a[key]}
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok && key != "updated_at" && key != "version" {
+			diff[key] = map[string]interface{}{"from": nil, "to": a[key]}
+		}
+	}
+
+	return json.Marshal(diff)
+}
+
+func nullableJSON(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
+
+func nullJSON(b []byte) json.RawMessage {
+	if b == nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(b)
+}
+
+// snapshotTime reads the timestamps of a product snapshot. to_jsonb writes timestamp columns
+// without a time zone, which time.Time does not accept.
+type snapshotTime struct {
+	time.Time
+}
+
+func (t *snapshotTime) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	t.Time, err = time.Parse("2006-01-02T15:04:05.999999999", s)
+	if err != nil {
+		t.Time, err = time.Parse(time.RFC3339Nano, s)
+	}
+
+	return err
+}
+
+// value is the timestamp to store, or nil for a missing one
+func (t *snapshotTime) value() interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Time
+}
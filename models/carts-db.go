@@ -0,0 +1,359 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInsufficientStock is returned when a cart asks for more of a product than is in stock
+var ErrInsufficientStock = errors.New("not enough stock")
+
+// CartOwner names a persisted cart: the cart of a signed in user when UserID is set, and the
+// anonymous cart of a cart token otherwise
+type CartOwner struct {
+	UserID int
+	Token  string
+}
+
+func (o CartOwner) filter() (string, interface{}) {
+	if o.UserID > 0 {
+		return "user_id = $1", o.UserID
+	}
+	return "token = $1", o.Token
+}
+
+// GetCart returns the cart of the owner, priced now. An owner without a cart gets an empty one.
+func (m *DBModel) GetCart(owner CartOwner) (*Cart, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	id, err := cartID(ctx, m.DB, owner, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		cart := &Cart{Items: []*CartItem{}, Subtotal: Money{Currency: m.store.Currency}}
+		if owner.UserID == 0 {
+			cart.Token = owner.Token
+		}
+		return cart, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return m.cart(ctx, id)
+}
+
+// AddCartItem adds a quantity of a product to the owner's cart, creating the cart if need be.
+// Adding a product already in the cart raises its quantity.
+func (m *DBModel) AddCartItem(owner CartOwner, productID int, size string, quantity int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := cartID(ctx, tx, owner, true)
+	if err != nil {
+		return err
+	}
+
+	var inCart int
+	err = tx.QueryRowContext(ctx, `select quantity from cart_items where cart_id = $1 and product_id = $2 and size = $3`,
+		id, productID, size).Scan(&inCart)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = m.checkCartItem(ctx, tx, productID, size, inCart+quantity)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into cart_items (cart_id, product_id, size, quantity, added_at) values ($1, $2, $3, $4, $5)
+			on conflict (cart_id, product_id, size) do update set quantity = cart_items.quantity + excluded.quantity`
+
+	_, err = tx.ExecContext(ctx, stmt, id, productID, size, quantity, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = touchCart(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetCartItemQuantity changes the quantity of a product in the owner's cart. A quantity of 0
+// removes it. It returns sql.ErrNoRows when the product is not in the cart.
+func (m *DBModel) SetCartItemQuantity(owner CartOwner, productID int, size string, quantity int) error {
+	if quantity == 0 {
+		return m.RemoveCartItem(owner, productID, size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := cartID(ctx, tx, owner, false)
+	if err != nil {
+		return err
+	}
+
+	err = m.checkCartItem(ctx, tx, productID, size, quantity)
+	if err != nil {
+		return err
+	}
+
+	err = execOne(ctx, tx, `update cart_items set quantity = $1 where cart_id = $2 and product_id = $3 and size = $4`,
+		quantity, id, productID, size)
+	if err != nil {
+		return err
+	}
+
+	err = touchCart(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveCartItem takes a product out of the owner's cart. It returns sql.ErrNoRows when the
+// product is not in the cart.
+func (m *DBModel) RemoveCartItem(owner CartOwner, productID int, size string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := cartID(ctx, tx, owner, false)
+	if err != nil {
+		return err
+	}
+
+	err = execOne(ctx, tx, `delete from cart_items where cart_id = $1 and product_id = $2 and size = $3`, id, productID, size)
+	if err != nil {
+		return err
+	}
+
+	err = touchCart(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClearCart empties the owner's cart
+func (m *DBModel) ClearCart(owner CartOwner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	filter, arg := owner.filter()
+	_, err := m.DB.ExecContext(ctx, `delete from cart_items where cart_id in (select id from carts where `+filter+`)`, arg)
+
+	return err
+}
+
+// MergeCart moves the anonymous cart of a cart token into the cart of a user who signed in.
+// Quantities of products in both carts are added up, up to the stock of the product; products
+// out of stock are left out. The anonymous cart is gone afterwards.
+func (m *DBModel) MergeCart(token string, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	anonymousID, err := cartID(ctx, tx, CartOwner{Token: token}, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	userCartID, err := cartID(ctx, tx, CartOwner{UserID: userID}, true)
+	if err != nil {
+		return err
+	}
+
+	// as when adding to a cart, no more is put in the cart than is in stock
+	stmt := `insert into cart_items (cart_id, product_id, size, quantity, added_at)
+				select $1, i.product_id, i.size, least(i.quantity, p.stock), i.added_at
+				from cart_items i join products p on (p.id = i.product_id)
+				where i.cart_id = $2 and p.deleted_at is null and p.stock > 0
+			on conflict (cart_id, product_id, size) do update set quantity = least(cart_items.quantity + excluded.quantity,
+				(select stock from products where id = excluded.product_id))`
+
+	_, err = tx.ExecContext(ctx, stmt, userCartID, anonymousID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from carts where id = $1`, anonymousID)
+	if err != nil {
+		return err
+	}
+
+	err = touchCart(ctx, tx, userCartID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CartTokenIssued tells whether a cart was handed out for a cart token
+func (m *DBModel) CartTokenIssued(token string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var issued bool
+	err := m.DB.QueryRowContext(ctx, `select exists (select 1 from carts where token = $1)`, token).Scan(&issued)
+
+	return issued, err
+}
+
+// cartID returns the id of the owner's cart, creating the cart when create is set. Without
+// create it returns sql.ErrNoRows for an owner that has no cart.
+func cartID(ctx context.Context, db dbtx, owner CartOwner, create bool) (int, error) {
+	if owner.UserID == 0 && owner.Token == "" {
+		return 0, sql.ErrNoRows
+	}
+
+	if create {
+		var userID, token interface{}
+		if owner.UserID > 0 {
+			userID = owner.UserID
+		} else {
+			token = owner.Token
+		}
+
+		_, err := db.ExecContext(ctx, `insert into carts (user_id, token) values ($1, $2) on conflict do nothing`, userID, token)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	filter, arg := owner.filter()
+
+	var id int
+	err := db.QueryRowContext(ctx, `select id from carts where `+filter, arg).Scan(&id)
+
+	return id, err
+}
+
+// touchCart marks a cart as changed now. A changed cart is in use again, so reminders about
+// it having been abandoned start over.
+func touchCart(ctx context.Context, db dbtx, id int) error {
+	_, err := db.ExecContext(ctx, `update carts set updated_at = $1, reminders_sent = 0, last_reminded_at = null where id = $2`,
+		time.Now(), id)
+	return err
+}
+
+// checkCartItem makes sure a product can be put in a cart in the given size and quantity
+func (m *DBModel) checkCartItem(ctx context.Context, db dbtx, productID int, size string, quantity int) error {
+	product, err := m.scanProduct(db.QueryRowContext(ctx,
+		`select `+productColumns+` from products where id = $1 and deleted_at is null`, productID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %d", ErrUnknownProduct, productID)
+	} else if err != nil {
+		return err
+	}
+
+	if len(product.Size) > 0 {
+		found := false
+		for _, s := range product.Size {
+			found = found || s == size
+		}
+		if !found {
+			return fmt.Errorf("%w: %d has no size %q", ErrUnknownProduct, productID, size)
+		}
+	}
+	if quantity > product.Stock {
+		return fmt.Errorf("%w: %d of product %d left", ErrInsufficientStock, product.Stock, productID)
+	}
+
+	return nil
+}
+
+// cart reads a cart and prices its items at the effective prices of their products
+func (m *DBModel) cart(ctx context.Context, id int) (*Cart, error) {
+	cart := Cart{ID: id, Items: []*CartItem{}, Subtotal: Money{Currency: m.store.Currency}, Available: true}
+
+	err := m.DB.QueryRowContext(ctx, `select coalesce(user_id, 0), coalesce(token, ''), coalesce(email, ''), updated_at
+			from carts where id = $1`, id).
+		Scan(&cart.UserID, &cart.Token, &cart.Email, &cart.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select product_id, size, quantity, added_at from cart_items
+			where cart_id = $1 order by added_at, product_id, size`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item CartItem
+		err := rows.Scan(&item.ProductID, &item.Size, &item.Quantity, &item.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+		cart.Items = append(cart.Items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, item := range cart.Items {
+		product, err := m.scanProduct(m.DB.QueryRowContext(ctx, `select `+productColumns+` from products where id = $1`, item.ProductID))
+		if err != nil {
+			return nil, err
+		}
+
+		discounts, err := m.productDiscounts(ctx, item.ProductID)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Title = product.Title
+		item.Price = EffectivePrice(product, discounts, now).Price
+		item.LineTotal = item.Price.Mul(item.Quantity)
+		item.Stock = product.Stock
+		item.Available = product.DeletedAt == nil && item.Quantity <= product.Stock
+
+		if !item.Available {
+			cart.Available = false
+			continue
+		}
+		cart.Count += item.Quantity
+		cart.Subtotal = cart.Subtotal.Add(item.LineTotal)
+	}
+	if len(cart.Items) == 0 {
+		cart.Available = false
+	}
+
+	return &cart, nil
+}
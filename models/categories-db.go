@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// ErrUnknownCategory is returned when a product is put in a category that does not exist
+var ErrUnknownCategory = errors.New("unknown category")
+
+// CategoryTree returns the root categories with their subcategories nested under them
+func (m *DBModel) CategoryTree() ([]*Category, error) {
+	categories, err := m.GetAllCategory()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+
+	var roots []*Category
+
+	for _, c := range categories {
+		parent, ok := byID[c.ParentID]
+		if c.ParentID == 0 || !ok {
+			roots = append(roots, c)
+			continue
+		}
+		parent.Children = append(parent.Children, c)
+	}
+
+	return roots, nil
+}
+
+// checkCategories returns ErrUnknownCategory, naming them, if any of the categories does not exist
+func checkCategories(ctx context.Context, db dbtx, categoryIDs []int) error {
+	query := `select id from unnest($1::integer[]) as id where id not in (select id from category) order by id`
+
+	missing, err := scanIDs(db.QueryContext(ctx, query, pq.Array(categoryIDs)))
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %v", ErrUnknownCategory, missing)
+	}
+
+	return nil
+}
+
+// replaceCategories links a product to exactly the given categories. Check them with
+// checkCategories first: an unknown one fails on the foreign key.
+func replaceCategories(ctx context.Context, tx dbtx, productID int, categoryIDs []int) error {
+	_, err := tx.ExecContext(ctx, `delete from products_category where product_id = $1`, productID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into products_category (product_id, category_id, created_at, updated_at)
+			select distinct $1, id, $3::timestamp, $3::timestamp from unnest($2::integer[]) as id`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		productID,
+		pq.Array(categoryIDs),
+		time.Now(),
+	)
+
+	return err
+}
+
+// productCategories returns the categories a product belongs to, ordered by name
+func (m *DBModel) productCategories(ctx context.Context, productID int) ([]CategoryRef, error) {
+	query := `select
+				c.id, c.category_name
+			from
+				products_category pc
+				join category c on (c.id = pc.category_id)
+			where
+				pc.product_id = $1
+			order by c.category_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []CategoryRef{}
+
+	for rows.Next() {
+		var ref CategoryRef
+		err := rows.Scan(&ref.ID, &ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, ref)
+	}
+
+	return categories, rows.Err()
+}
+
+// breadcrumbs returns the path from the root category down to each category of a product
+func (m *DBModel) breadcrumbs(ctx context.Context, productID int) ([][]CategoryRef, error) {
+	query := `with recursive path as (
+				select pc.category_id as leaf, c.id, c.parent_id, c.category_name, 0 as depth
+				from products_category pc
+				join category c on (c.id = pc.category_id)
+				where pc.product_id = $1
+				union all
+				select p.leaf, c.id, c.parent_id, c.category_name, p.depth + 1
+				from path p
+				join category c on (c.id = p.parent_id)
+				where p.depth < 32
+			)
+			select leaf, id, category_name from path order by leaf, depth desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths [][]CategoryRef
+	var trail []CategoryRef
+	current := 0
+
+	for rows.Next() {
+		var leaf int
+		var ref CategoryRef
+
+		err := rows.Scan(&leaf, &ref.ID, &ref.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if leaf != current && trail != nil {
+			paths = append(paths, trail)
+			trail = nil
+		}
+		current = leaf
+		trail = append(trail, ref)
+	}
+	if trail != nil {
+		paths = append(paths, trail)
+	}
+
+	return paths, rows.Err()
+}
@@ -0,0 +1,306 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCouponInvalid is returned when a coupon does not exist or cannot be used on a cart.
+// The wrapping error tells why.
+var ErrCouponInvalid = errors.New("coupon cannot be used")
+
+const couponColumns = `c.id, c.code, c.kind, c.value, c.min_order, c.product_ids, c.category_ids, c.usage_limit,
+				c.per_user_limit, c.starts_at, c.ends_at, c.active, c.created_at, c.updated_at,
+				(select count(*) from coupon_redemptions r where r.coupon_id = c.id)`
+
+// AllCoupons returns every coupon with how often it has been redeemed, newest first
+func (m *DBModel) AllCoupons() ([]*Coupon, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.scanCoupons(m.DB.QueryContext(ctx, `select `+couponColumns+` from coupons c order by c.id desc`))
+}
+
+// GetCoupon returns one coupon
+func (m *DBModel) GetCoupon(id int) (*Coupon, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	coupons, err := m.scanCoupons(m.DB.QueryContext(ctx, `select `+couponColumns+` from coupons c where c.id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if len(coupons) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return coupons[0], nil
+}
+
+// InsertCoupon adds a coupon. Codes are stored upper case and matched regardless of case.
+func (m *DBModel) InsertCoupon(c Coupon) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into coupons (code, kind, value, min_order, product_ids, category_ids, usage_limit, per_user_limit,
+				starts_at, ends_at, active, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12) returning id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		normalizeCode(c.Code),
+		c.Kind,
+		c.Value,
+		c.MinOrder,
+		pq.Array(nonNilInts(c.ProductIDs)),
+		pq.Array(nonNilInts(c.CategoryIDs)),
+		c.UsageLimit,
+		c.PerUserLimit,
+		utc(c.StartsAt),
+		utc(c.EndsAt),
+		c.Active,
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+func (m *DBModel) UpdateCoupon(c Coupon) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update coupons set code = $1, kind = $2, value = $3, min_order = $4, product_ids = $5, category_ids = $6,
+				usage_limit = $7, per_user_limit = $8, starts_at = $9, ends_at = $10, active = $11, updated_at = $12
+			where id = $13`
+
+	return execOne(ctx, m.DB, stmt,
+		normalizeCode(c.Code),
+		c.Kind,
+		c.Value,
+		c.MinOrder,
+		pq.Array(nonNilInts(c.ProductIDs)),
+		pq.Array(nonNilInts(c.CategoryIDs)),
+		c.UsageLimit,
+		c.PerUserLimit,
+		utc(c.StartsAt),
+		utc(c.EndsAt),
+		c.Active,
+		time.Now(),
+		c.ID,
+	)
+}
+
+// PreviewCoupon prices a cart and tells what the coupon would take off it, without redeeming it
+func (m *DBModel) PreviewCoupon(cp *CartProducts, code string) (*CouponResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.priceCart(ctx, cp)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.applyCoupon(ctx, m.DB, cp, code, false)
+}
+
+// applyCoupon checks that a coupon can be used on a priced cart and works out its discount.
+// With lock the coupon row is locked until the transaction ends, so that concurrent orders
+// cannot both take the last use of a limited coupon.
+func (m *DBModel) applyCoupon(ctx context.Context, db dbtx, cp *CartProducts, code string, lock bool) (*CouponResult, error) {
+	if lock {
+		// lock before counting redemptions, so that the count includes those committed while waiting
+		_, err := db.ExecContext(ctx, `select id from coupons where code = $1 for update`, normalizeCode(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := `select ` + couponColumns + ` from coupons c where c.code = $1`
+
+	coupons, err := m.scanCoupons(db.QueryContext(ctx, query, normalizeCode(code)))
+	if err != nil {
+		return nil, err
+	}
+	if len(coupons) == 0 {
+		return nil, fmt.Errorf("%w: unknown code", ErrCouponInvalid)
+	}
+	c := coupons[0]
+
+	err = c.usable(time.Now(), cp.Total)
+	if err != nil {
+		return nil, err
+	}
+	if c.PerUserLimit != nil {
+		// guests are told apart by their email, users by their account
+		var used int
+		switch {
+		case cp.UserID > 0:
+			err = db.QueryRowContext(ctx, `select count(*) from coupon_redemptions where coupon_id = $1 and user_id = $2`,
+				c.ID, cp.UserID).Scan(&used)
+		case cp.Email != "":
+			err = db.QueryRowContext(ctx, `select count(*) from coupon_redemptions where coupon_id = $1 and lower(email) = lower($2)`,
+				c.ID, cp.Email).Scan(&used)
+		default:
+			return nil, fmt.Errorf("%w: sign in or give an email to use this code", ErrCouponInvalid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if used >= *c.PerUserLimit {
+			return nil, fmt.Errorf("%w: already used", ErrCouponInvalid)
+		}
+	}
+
+	eligible := Money{Currency: m.store.Currency}
+	for i := range cp.ProductID {
+		applies, err := m.couponApplies(ctx, db, c, cp.ProductID[i])
+		if err != nil {
+			return nil, err
+		}
+		if applies {
+			quantity, _ := strconv.Atoi(cp.Quantity[i])
+			eligible = eligible.Add(cp.Price[i].Mul(quantity))
+		}
+	}
+
+	return c.apply(cp.Total, eligible)
+}
+
+// usable checks the conditions of a coupon that do not depend on who uses it or on which products
+func (c *Coupon) usable(now time.Time, subtotal Money) error {
+	switch {
+	case !c.Active || !within(now, c.StartsAt, c.EndsAt):
+		return fmt.Errorf("%w: not valid at this time", ErrCouponInvalid)
+	case c.UsageLimit != nil && c.Redemptions >= *c.UsageLimit:
+		return fmt.Errorf("%w: usage limit reached", ErrCouponInvalid)
+	case subtotal.Amount < c.MinOrder.Amount:
+		return fmt.Errorf("%w: the order must be at least %s", ErrCouponInvalid, c.MinOrder)
+	}
+
+	return nil
+}
+
+// apply works out what a coupon takes off a cart whose qualifying lines come to eligible.
+// The discount never exceeds eligible.
+func (c *Coupon) apply(subtotal, eligible Money) (*CouponResult, error) {
+	if eligible.Amount == 0 {
+		return nil, fmt.Errorf("%w: no item in the cart qualifies", ErrCouponInvalid)
+	}
+
+	result := CouponResult{Code: c.Code, Subtotal: subtotal, Discount: Money{Currency: subtotal.Currency}}
+
+	switch c.Kind {
+	case CouponPercent:
+		result.Discount = eligible.Percent(c.Value.Amount)
+	case CouponFixed:
+		result.Discount = c.Value
+	case CouponFreeShipping:
+		result.FreeShipping = true
+	}
+	if result.Discount.Amount > eligible.Amount {
+		result.Discount = eligible
+	}
+	result.Total = subtotal.Sub(result.Discount)
+
+	return &result, nil
+}
+
+// couponApplies tells whether a cart line qualifies for a coupon: always for unrestricted coupons,
+// otherwise if the product is listed or sits in a listed category or one of its subcategories
+func (m *DBModel) couponApplies(ctx context.Context, db dbtx, c *Coupon, productID string) (bool, error) {
+	if len(c.ProductIDs) == 0 && len(c.CategoryIDs) == 0 {
+		return true, nil
+	}
+
+	id, err := strconv.Atoi(productID)
+	if err != nil {
+		return false, nil
+	}
+	for _, p := range c.ProductIDs {
+		if p == id {
+			return true, nil
+		}
+	}
+	if len(c.CategoryIDs) == 0 {
+		return false, nil
+	}
+
+	query := `with recursive scope as (
+				select category_id as id from products_category where product_id = $1
+				union
+				select c.parent_id from category c join scope s on (c.id = s.id) where c.parent_id is not null
+			)
+			select exists(select 1 from scope where id = any($2))`
+
+	var applies bool
+	err = db.QueryRowContext(ctx, query, id, pq.Array(c.CategoryIDs)).Scan(&applies)
+
+	return applies, err
+}
+
+// redeemCoupon records the use of a coupon by an order, in the transaction that created the order.
+// Guest orders have no user but an email.
+func redeemCoupon(ctx context.Context, tx dbtx, code string, orderID, userID int, email string, discount int) error {
+	stmt := `insert into coupon_redemptions (coupon_id, order_id, user_id, email, discount, created_at)
+			select id, $2, $3, $4, $5, $6 from coupons where code = $1`
+
+	return execOne(ctx, tx, stmt, normalizeCode(code), orderID, nullID(userID), nullString(email), discount, time.Now())
+}
+
+// scanCoupons reads rows of couponColumns, with minimum orders and fixed values in the store currency
+func (m *DBModel) scanCoupons(rows *sql.Rows, err error) ([]*Coupon, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	coupons := []*Coupon{}
+
+	for rows.Next() {
+		var c Coupon
+		err := rows.Scan(
+			&c.ID,
+			&c.Code,
+			&c.Kind,
+			&c.Value,
+			&c.MinOrder,
+			pq.Array(&c.ProductIDs),
+			pq.Array(&c.CategoryIDs),
+			&c.UsageLimit,
+			&c.PerUserLimit,
+			&c.StartsAt,
+			&c.EndsAt,
+			&c.Active,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.Redemptions,
+		)
+		if err != nil {
+			return nil, err
+		}
+		c.MinOrder.Currency = m.store.Currency
+		if c.Kind == CouponFixed {
+			c.Value.Currency = m.store.Currency
+		}
+		coupons = append(coupons, &c)
+	}
+
+	return coupons, rows.Err()
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func nonNilInts(ids []int) []int {
+	if ids == nil {
+		return []int{}
+	}
+	return ids
+}
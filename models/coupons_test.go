@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCouponUsable(t *testing.T) {
+	eur := func(amount int) Money { return Money{Amount: amount, Currency: "EUR"} }
+	limit := func(n int) *int { return &n }
+
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		subtotal Money
+		wantErr  bool
+	}{
+		{"active", Coupon{Active: true}, eur(1000), false},
+		{"inactive", Coupon{}, eur(1000), true},
+		{"within window", Coupon{Active: true, StartsAt: &yesterday, EndsAt: &tomorrow}, eur(1000), false},
+		{"not started", Coupon{Active: true, StartsAt: &tomorrow}, eur(1000), true},
+		{"ended", Coupon{Active: true, EndsAt: &yesterday}, eur(1000), true},
+		{"under usage limit", Coupon{Active: true, UsageLimit: limit(3), Redemptions: 2}, eur(1000), false},
+		{"usage limit reached", Coupon{Active: true, UsageLimit: limit(3), Redemptions: 3}, eur(1000), true},
+		{"minimum order met", Coupon{Active: true, MinOrder: eur(1000)}, eur(1000), false},
+		{"under minimum order", Coupon{Active: true, MinOrder: eur(1000)}, eur(999), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.coupon.usable(now, tt.subtotal)
+			if tt.wantErr && !errors.Is(err, ErrCouponInvalid) {
+				t.Errorf("got %v, want %v", err, ErrCouponInvalid)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got %v, want no error", err)
+			}
+		})
+	}
+}
+
+func TestCouponApply(t *testing.T) {
+	eur := func(amount int) Money { return Money{Amount: amount, Currency: "EUR"} }
+
+	tests := []struct {
+		name         string
+		coupon       Coupon
+		subtotal     Money
+		eligible     Money
+		wantDiscount Money
+		wantTotal    Money
+		wantShipping bool
+		wantErr      bool
+	}{
+		{
+			name:         "percent of the whole cart",
+			coupon:       Coupon{Kind: CouponPercent, Value: Money{Amount: 10}},
+			subtotal:     eur(5000),
+			eligible:     eur(5000),
+			wantDiscount: eur(500),
+			wantTotal:    eur(4500),
+		},
+		{
+			name:         "percent of qualifying lines only",
+			coupon:       Coupon{Kind: CouponPercent, Value: Money{Amount: 10}},
+			subtotal:     eur(5000),
+			eligible:     eur(2000),
+			wantDiscount: eur(200),
+			wantTotal:    eur(4800),
+		},
+		{
+			name:         "fixed",
+			coupon:       Coupon{Kind: CouponFixed, Value: eur(1500)},
+			subtotal:     eur(5000),
+			eligible:     eur(5000),
+			wantDiscount: eur(1500),
+			wantTotal:    eur(3500),
+		},
+		{
+			name:         "fixed capped at qualifying lines",
+			coupon:       Coupon{Kind: CouponFixed, Value: eur(1500)},
+			subtotal:     eur(5000),
+			eligible:     eur(1000),
+			wantDiscount: eur(1000),
+			wantTotal:    eur(4000),
+		},
+		{
+			name:         "free shipping",
+			coupon:       Coupon{Kind: CouponFreeShipping},
+			subtotal:     eur(5000),
+			eligible:     eur(5000),
+			wantDiscount: eur(0),
+			wantTotal:    eur(5000),
+			wantShipping: true,
+		},
+		{
+			name:     "nothing qualifies",
+			coupon:   Coupon{Kind: CouponPercent, Value: Money{Amount: 10}},
+			subtotal: eur(5000),
+			eligible: eur(0),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.coupon.apply(tt.subtotal, tt.eligible)
+			if tt.wantErr {
+				if !errors.Is(err, ErrCouponInvalid) {
+					t.Errorf("got %v, want %v", err, ErrCouponInvalid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Discount != tt.wantDiscount {
+				t.Errorf("discount %v, want %v", got.Discount, tt.wantDiscount)
+			}
+			if got.Total != tt.wantTotal {
+				t.Errorf("total %v, want %v", got.Total, tt.wantTotal)
+			}
+			if got.FreeShipping != tt.wantShipping {
+				t.Errorf("free shipping %t, want %t", got.FreeShipping, tt.wantShipping)
+			}
+		})
+	}
+}
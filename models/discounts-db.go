@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const discountColumns = `id, name, kind, value, coalesce(product_id, 0), coalesce(category_id, 0),
+				starts_at, ends_at, active, created_at, updated_at`
+
+// AllDiscounts returns every discount, newest first
+func (m *DBModel) AllDiscounts() ([]*Discount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.scanDiscounts(m.DB.QueryContext(ctx, `select `+discountColumns+` from discounts order by id desc`))
+}
+
+// GetDiscount returns one discount
+func (m *DBModel) GetDiscount(id int) (*Discount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	discounts, err := m.scanDiscounts(m.DB.QueryContext(ctx, `select `+discountColumns+` from discounts where id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if len(discounts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return discounts[0], nil
+}
+
+func (m *DBModel) InsertDiscount(d Discount) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into discounts (name, kind, value, product_id, category_id, starts_at, ends_at, active, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9) returning id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		d.Name,
+		d.Kind,
+		d.Value,
+		nullID(d.ProductID),
+		nullID(d.CategoryID),
+		utc(d.StartsAt),
+		utc(d.EndsAt),
+		d.Active,
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+func (m *DBModel) UpdateDiscount(d Discount) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update discounts set name = $1, kind = $2, value = $3, product_id = $4, category_id = $5,
+				starts_at = $6, ends_at = $7, active = $8, updated_at = $9
+			where id = $10`
+
+	return execOne(ctx, m.DB, stmt,
+		d.Name,
+		d.Kind,
+		d.Value,
+		nullID(d.ProductID),
+		nullID(d.CategoryID),
+		utc(d.StartsAt),
+		utc(d.EndsAt),
+		d.Active,
+		time.Now(),
+		d.ID,
+	)
+}
+
+func (m *DBModel) DeleteDiscount(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `delete from discounts where id = $1`, id)
+}
+
+// productDiscounts returns the active discounts scoped to a product, to one of its categories or
+// to an ancestor of those. Whether they are running right now is left to EffectivePrice.
+func (m *DBModel) productDiscounts(ctx context.Context, productID int) ([]*Discount, error) {
+	query := `with recursive scope as (
+				select category_id as id from products_category where product_id = $1
+				union
+				select c.parent_id from category c join scope s on (c.id = s.id) where c.parent_id is not null
+			)
+			select ` + discountColumns + ` from discounts
+			where active and (product_id = $1 or category_id in (select id from scope))`
+
+	return m.scanDiscounts(m.DB.QueryContext(ctx, query, productID))
+}
+
+// scanDiscounts reads rows of discountColumns, with fixed values in the store currency
+func (m *DBModel) scanDiscounts(rows *sql.Rows, err error) ([]*Discount, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discounts := []*Discount{}
+
+	for rows.Next() {
+		var d Discount
+		err := rows.Scan(
+			&d.ID,
+			&d.Name,
+			&d.Kind,
+			&d.Value,
+			&d.ProductID,
+			&d.CategoryID,
+			&d.StartsAt,
+			&d.EndsAt,
+			&d.Active,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if d.Kind == DiscountFixed {
+			d.Value.Currency = m.store.Currency
+		}
+		discounts = append(discounts, &d)
+	}
+
+	return discounts, rows.Err()
+}
+
+// nullID stores a zero id as NULL, for optional foreign keys
+func nullID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// exportPageSize is how many products EachProduct reads from the database at a time
+const exportPageSize = 200
+
+// EachProduct calls fn for every product in id order, reading them a page at a time so that
+// the whole catalog is never held in memory. Archived products are skipped unless includeArchived
+// is set. Iteration stops at the first error, from the database or from fn.
+func (m *DBModel) EachProduct(ctx context.Context, includeArchived bool, fn func(*Product) error) error {
+	query := `select ` + productColumns + ` from products where id > $1`
+	if !includeArchived {
+		query += " and deleted_at is null"
+	}
+	query += " order by id limit $2"
+
+	lastID := 0
+
+	for {
+		page, err := m.productPage(ctx, query, lastID)
+		if err != nil {
+			return err
+		}
+
+		for _, product := range page {
+			err = fn(product)
+			if err != nil {
+				return err
+			}
+			lastID = product.ID
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+	}
+}
+
+func (m *DBModel) productPage(ctx context.Context, query string, afterID int) ([]*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, exportPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []*Product
+
+	for rows.Next() {
+		product, err := m.scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, product := range products {
+		err = m.withRelations(ctx, product)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return products, nil
+}
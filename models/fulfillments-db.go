@@ -0,0 +1,216 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// ErrFulfillmentInvalid is returned when a fulfillment holds items its order does not have,
+// or more of them than are left to send. The wrapping error tells which.
+var ErrFulfillmentInvalid = errors.New("invalid fulfillment")
+
+// GetOrder returns one order with its fulfillments
+func (m *DBModel) GetOrder(id int) (*CartProducts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	orders, err := m.orders(ctx, "o.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	order := orders[0]
+
+	order.Fulfillments, err = m.orderFulfillments(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// InsertFulfillment records a parcel sent for a paid order, and moves the order to shipped, or to
+// partially shipped while some of its items are left to send
+func (m *DBModel) InsertFulfillment(f Fulfillment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the order so that concurrent fulfillments cannot both send the last items
+	var ordered []string
+	var status string
+	err = tx.QueryRowContext(ctx, `select quantity, status from orders where id = $1 for update`, f.OrderID).Scan(
+		pq.Array(&ordered), &status)
+	if err != nil {
+		return 0, err
+	}
+	if status != OrderPaid && status != OrderPartiallyShipped {
+		return 0, fmt.Errorf("%w: a %s order cannot be shipped", ErrStatusTransition, status)
+	}
+
+	sent, err := sentQuantities(ctx, tx, f.OrderID)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(f.Items) == 0 {
+		return 0, fmt.Errorf("%w: no items", ErrFulfillmentInvalid)
+	}
+	seen := make(map[int]bool)
+	for _, item := range f.Items {
+		if item.Line < 0 || item.Line >= len(ordered) {
+			return 0, fmt.Errorf("%w: the order has no line %d", ErrFulfillmentInvalid, item.Line)
+		}
+		if seen[item.Line] {
+			return 0, fmt.Errorf("%w: line %d is listed twice", ErrFulfillmentInvalid, item.Line)
+		}
+		seen[item.Line] = true
+
+		quantity, _ := strconv.Atoi(ordered[item.Line])
+		left := quantity - sent[item.Line]
+		if item.Quantity < 1 || item.Quantity > left {
+			return 0, fmt.Errorf("%w: line %d has %d left to send", ErrFulfillmentInvalid, item.Line, left)
+		}
+		sent[item.Line] += item.Quantity
+	}
+
+	stmt := `insert into fulfillments (order_id, carrier, tracking_number, tracking_url, shipped_at, created_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+
+	var id int
+	err = tx.QueryRowContext(ctx, stmt,
+		f.OrderID,
+		f.Carrier,
+		f.TrackingNumber,
+		f.TrackingURL,
+		f.ShippedAt.UTC(),
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range f.Items {
+		_, err = tx.ExecContext(ctx, `insert into fulfillment_items (fulfillment_id, line, quantity) values ($1, $2, $3)`,
+			id, item.Line, item.Quantity)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	status = OrderShipped
+	for line, q := range ordered {
+		quantity, _ := strconv.Atoi(q)
+		if sent[line] < quantity {
+			status = OrderPartiallyShipped
+			break
+		}
+	}
+
+	err = execOne(ctx, tx, `update orders set status = $1 where id = $2`, status, f.OrderID)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// sentQuantities returns how many items of every line of an order have been sent, by line
+func sentQuantities(ctx context.Context, db dbtx, orderID int) (map[int]int, error) {
+	query := `select i.line, sum(i.quantity)
+			from fulfillment_items i join fulfillments f on (f.id = i.fulfillment_id)
+			where f.order_id = $1
+			group by i.line`
+
+	rows, err := db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sent := make(map[int]int)
+	for rows.Next() {
+		var line, quantity int
+		err := rows.Scan(&line, &quantity)
+		if err != nil {
+			return nil, err
+		}
+		sent[line] = quantity
+	}
+
+	return sent, rows.Err()
+}
+
+// orderFulfillments returns the fulfillments of an order in the order they were shipped,
+// with their items
+func (m *DBModel) orderFulfillments(ctx context.Context, order *CartProducts) ([]*Fulfillment, error) {
+	query := `select id, order_id, carrier, tracking_number, tracking_url, shipped_at, created_at
+			from fulfillments where order_id = $1 order by shipped_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fulfillments := []*Fulfillment{}
+
+	for rows.Next() {
+		var f Fulfillment
+		err := rows.Scan(&f.ID, &f.OrderID, &f.Carrier, &f.TrackingNumber, &f.TrackingURL, &f.ShippedAt, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		fulfillments = append(fulfillments, &f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, f := range fulfillments {
+		f.Items, err = fulfillmentItems(ctx, m.DB, f.ID, order)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fulfillments, nil
+}
+
+func fulfillmentItems(ctx context.Context, db dbtx, fulfillmentID int, order *CartProducts) ([]FulfillmentItem, error) {
+	rows, err := db.QueryContext(ctx, `select line, quantity from fulfillment_items where fulfillment_id = $1 order by line`, fulfillmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []FulfillmentItem{}
+
+	for rows.Next() {
+		var item FulfillmentItem
+		err := rows.Scan(&item.Line, &item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		if item.Line < len(order.ProductID) {
+			item.ProductID = order.ProductID[item.Line]
+			item.Size = order.Size[item.Line]
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
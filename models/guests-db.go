@@ -0,0 +1,120 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrEmailTaken is returned when a guest is turned into an account for an email that already has one
+var ErrEmailTaken = errors.New("email already in use")
+
+// GuestOrder returns the guest order a lookup token was issued for, with its fulfillments
+func (m *DBModel) GuestOrder(token string) (*CartProducts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	orders, err := m.orders(ctx, "o.lookup_token_hash = $1", hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	order := orders[0]
+
+	order.Fulfillments, err = m.orderFulfillments(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// ConvertGuest creates an account for the guest holding a lookup token, with the email of the
+// order the token was issued for. Having the token proves the guest reads mail at that email, so
+// every guest order placed with it is attached to the new account. It returns the id of the user
+// and how many orders were attached.
+func (m *DBModel) ConvertGuest(token string, u User) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `select email from orders where lookup_token_hash = $1 and user_id is null`,
+		hashToken(token)).Scan(&u.Email)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `select exists (select 1 from users where lower(email) = lower($1))`, u.Email).Scan(&taken)
+	if err != nil {
+		return 0, 0, err
+	}
+	if taken {
+		return 0, 0, ErrEmailTaken
+	}
+
+	stmt := `insert into users (first_name, last_name, phone, email, password, access_level, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var userID int
+	err = tx.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Phone,
+		u.Email,
+		u.Password,
+		"user",
+		time.Now(),
+		time.Now(),
+	).Scan(&userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `update orders set user_id = $1 where user_id is null and lower(email) = lower($2)`,
+		userID, u.Email)
+	if err != nil {
+		return 0, 0, err
+	}
+	attached, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update billing_info set user_id = $1
+			where user_id is null and order_id in (select id from orders where user_id = $1)`, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return userID, int(attached), tx.Commit()
+}
+
+// newLookupToken returns a random token for a guest to look an order up with
+func newLookupToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hash a token is stored as, so that a copy of the database does not
+// give access to what the tokens unlock
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ClaimIdempotencyKey claims a key of an owner for a request. It returns nil when the key is new,
+// or has expired, and the request is to be run; its response must then be saved with
+// SaveIdempotentResponse, or the key released. Otherwise it returns what is stored for the key.
+// A claim still without a response after abandonedBefore is taken to have been abandoned.
+func (m *DBModel) ClaimIdempotencyKey(owner, key, requestHash string, expiredBefore, abandonedBefore time.Time) (*IdempotentResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from idempotency_keys where owner = $1 and key = $2
+			and (created_at < $3 or (status is null and created_at < $4))`,
+		owner, key, expiredBefore, abandonedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := m.DB.ExecContext(ctx, `insert into idempotency_keys (owner, key, request_hash, created_at)
+			values ($1, $2, $3, $4) on conflict do nothing`, owner, key, requestHash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if claimed == 1 {
+		return nil, nil
+	}
+
+	var r IdempotentResponse
+	var status sql.NullInt64
+	var header []byte
+
+	err = m.DB.QueryRowContext(ctx, `select request_hash, status, header, body, created_at
+			from idempotency_keys where owner = $1 and key = $2`, owner, key).
+		Scan(&r.RequestHash, &status, &header, &r.Body, &r.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// released since the insert; the retry runs as a new request
+		return m.ClaimIdempotencyKey(owner, key, requestHash, expiredBefore, abandonedBefore)
+	} else if err != nil {
+		return nil, err
+	}
+	r.Status = int(status.Int64)
+
+	if header != nil {
+		err = json.Unmarshal(header, &r.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
+// SaveIdempotentResponse stores the response of the request that claimed a key
+func (m *DBModel) SaveIdempotentResponse(owner, key string, status int, header map[string][]string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	return execOne(ctx, m.DB, `update idempotency_keys set status = $1, header = $2, body = $3 where owner = $4 and key = $5`,
+		status, h, body, owner, key)
+}
+
+// ReleaseIdempotencyKey forgets a claimed key, so that the request can be retried
+func (m *DBModel) ReleaseIdempotencyKey(owner, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from idempotency_keys where owner = $1 and key = $2`, owner, key)
+	return err
+}
+
+// PurgeIdempotencyKeys deletes the keys claimed before cutoff and returns how many there were
+func (m *DBModel) PurgeIdempotencyKeys(cutoff time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `delete from idempotency_keys where created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,343 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// InsertProductImage adds an image to the gallery of a product and returns its id.
+// A sort order of -1 puts the image at the end of the gallery.
+func (m *DBModel) InsertProductImage(img ProductImage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the product so concurrent uploads agree on the gallery order and the primary image
+	var productID int
+	err = tx.QueryRowContext(ctx, `select id from products where id = $1 for update`, img.ProductID).Scan(&productID)
+	if err != nil {
+		return 0, err
+	}
+
+	before, err := productSnapshot(ctx, tx, img.ProductID)
+	if err != nil {
+		return 0, err
+	}
+
+	if img.Primary {
+		_, err = tx.ExecContext(ctx, `update product_images set is_primary = false where product_id = $1`, img.ProductID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	stmt := `insert into product_images (product_id, image_key, alt_text, sort_order, is_primary, width, height, created_at, updated_at, source)
+			values ($1, $2, $3,
+				case when $4 < 0 then (select coalesce(max(sort_order) + 1, 0) from product_images where product_id = $1) else $4 end,
+				$5, $6, $7, $8, $8, $9)
+			returning id`
+
+	var newID int
+	err = tx.QueryRowContext(ctx, stmt,
+		img.ProductID,
+		img.Key,
+		img.AltText,
+		img.SortOrder,
+		img.Primary,
+		img.Width,
+		img.Height,
+		time.Now(),
+		img.Source,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, v := range img.Variants {
+		_, err = tx.ExecContext(ctx, `insert into product_image_variants (image_id, name, format, image_key, width, height)
+				values ($1, $2, $3, $4, $5, $6)`,
+			newID,
+			v.Name,
+			v.Format,
+			v.Key,
+			v.Width,
+			v.Height,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = syncPrimaryImage(ctx, tx, img.ProductID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.audit(ctx, tx, img.ProductID, "image_added", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// UpdateProductImage updates the alt text, sort order and primary flag of an image
+func (m *DBModel) UpdateProductImage(img ProductImage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := productSnapshot(ctx, tx, img.ProductID)
+	if err != nil {
+		return err
+	}
+
+	if img.Primary {
+		_, err = tx.ExecContext(ctx, `update product_images set is_primary = false where product_id = $1`, img.ProductID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `update product_images set alt_text = $1, sort_order = $2, is_primary = $3, updated_at = $4
+			where id = $5 and product_id = $6`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		img.AltText,
+		img.SortOrder,
+		img.Primary,
+		time.Now(),
+		img.ID,
+		img.ProductID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = syncPrimaryImage(ctx, tx, img.ProductID)
+	if err != nil {
+		return err
+	}
+
+	err = m.audit(ctx, tx, img.ProductID, "image_updated", before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteProductImage removes an image from a gallery and returns the storage keys of the image and its variants
+func (m *DBModel) DeleteProductImage(productID, imageID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `select image_key from product_images where id = $1 and product_id = $2
+			union all
+			select v.image_key from product_image_variants v
+			join product_images i on (i.id = v.image_id)
+			where i.id = $1 and i.product_id = $2`
+
+	keys, err := scanKeys(tx.QueryContext(ctx, query, imageID, productID))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	before, err := productSnapshot(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from product_images where id = $1`, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syncPrimaryImage(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, tx, productID, "image_removed", before)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, tx.Commit()
+}
+
+// GetProductImage returns one image of a product with its variants
+func (m *DBModel) GetProductImage(productID, imageID int) (*ProductImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	images, err := m.productImages(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		if img.ID == imageID {
+			return img, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// productImageKeys returns every storage key used by the images of a product
+func productImageKeys(ctx context.Context, db dbtx, productID int) ([]string, error) {
+	query := `select image from products where id = $1 and image <> ''
+			union
+			select image_key from product_images where product_id = $1
+			union
+			select v.image_key from product_image_variants v
+			join product_images i on (i.id = v.image_id)
+			where i.product_id = $1`
+
+	return scanKeys(db.QueryContext(ctx, query, productID))
+}
+
+// productImages returns the gallery of a product in display order
+func (m *DBModel) productImages(ctx context.Context, productID int) ([]*ProductImage, error) {
+	query := `select id, product_id, image_key, source, alt_text, sort_order, is_primary, width, height, created_at, updated_at
+			from product_images
+			where product_id = $1
+			order by sort_order, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*ProductImage{}
+	var ids []int
+	byID := make(map[int]*ProductImage)
+
+	for rows.Next() {
+		var img ProductImage
+		err := rows.Scan(
+			&img.ID,
+			&img.ProductID,
+			&img.Key,
+			&img.Source,
+			&img.AltText,
+			&img.SortOrder,
+			&img.Primary,
+			&img.Width,
+			&img.Height,
+			&img.CreatedAt,
+			&img.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		img.Variants = []ImageVariant{}
+		images = append(images, &img)
+		ids = append(ids, img.ID)
+		byID[img.ID] = &img
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return images, nil
+	}
+
+	variantQuery := `select image_id, name, format, image_key, width, height
+			from product_image_variants
+			where image_id = any($1)
+			order by width, format`
+
+	variantRows, err := m.DB.QueryContext(ctx, variantQuery, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer variantRows.Close()
+
+	for variantRows.Next() {
+		var imageID int
+		var v ImageVariant
+		err := variantRows.Scan(&imageID, &v.Name, &v.Format, &v.Key, &v.Width, &v.Height)
+		if err != nil {
+			return nil, err
+		}
+		byID[imageID].Variants = append(byID[imageID].Variants, v)
+	}
+
+	return images, variantRows.Err()
+}
+
+// syncPrimaryImage makes sure a product with images has exactly one primary image,
+// and mirrors its key into products.image for clients that only read a single image
+func syncPrimaryImage(ctx context.Context, tx *sql.Tx, productID int) error {
+	stmt := `update product_images set is_primary = (id = (
+				select id from product_images where product_id = $1
+				order by is_primary desc, sort_order, id limit 1
+			))
+			where product_id = $1`
+
+	_, err := tx.ExecContext(ctx, stmt, productID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update products set image = coalesce(
+				(select image_key from product_images where product_id = $1 and is_primary), ''
+			), version = version + 1
+			where id = $1`
+
+	_, err = tx.ExecContext(ctx, stmt, productID)
+	return err
+}
+
+// scanKeys collects the single text column of rows
+func scanKeys(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		err := rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+// ImportRow is one product of a bulk import, matched to existing products by SKU
+type ImportRow struct {
+	Line        int
+	SKU         string
+	Title       string
+	Price       Money
+	Size        []string
+	Description string
+	Stock       int
+	Shipping    bool
+	Categories  []int
+	Images      []string
+}
+
+// ImportResult tells what happened to one row of a bulk import
+type ImportResult struct {
+	Line         int      `json:"line"`
+	SKU          string   `json:"sku"`
+	Status       string   `json:"status"`
+	ProductID    int      `json:"product_id,omitempty"`
+	ImagesQueued int      `json:"images_queued,omitempty"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// ImageImport is an image of a bulk import waiting to be downloaded into a product gallery,
+// on behalf of the admin who ran the import
+type ImageImport struct {
+	ID        int
+	ProductID int
+	Source    string
+	ActorID   int
+	RequestID string
+}
+
+// ImportProducts creates or updates the products of a batch in one transaction, so either every
+// row is saved or none is. With dryRun the transaction is rolled back once all rows went through.
+func (m *DBModel) ImportProducts(rows []ImportRow, dryRun bool) ([]ImportResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]ImportResult, 0, len(rows))
+
+	for _, row := range rows {
+		var existingID, stockBefore int
+		err := tx.QueryRowContext(ctx, `select id, stock from products where sku = $1 for update`, row.SKU).Scan(&existingID, &stockBefore)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		var before []byte
+		if existingID > 0 {
+			before, err = productSnapshot(ctx, tx, existingID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		stmt := `insert into products (sku, title, price, size, description, image, stock, shipping, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, '', $6, $7, $8, $8)
+				on conflict (sku) do update set
+					title = excluded.title, price = excluded.price, size = excluded.size,
+					description = excluded.description, stock = excluded.stock, shipping = excluded.shipping,
+					updated_at = excluded.updated_at, version = products.version + 1
+				returning id`
+
+		var id int
+		err = tx.QueryRowContext(ctx, stmt,
+			row.SKU,
+			row.Title,
+			row.Price,
+			pq.Array(row.Size),
+			row.Description,
+			row.Stock,
+			row.Shipping,
+			time.Now(),
+		).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		if row.Categories != nil {
+			err = checkCategories(ctx, tx, row.Categories)
+			if err != nil {
+				return nil, err
+			}
+
+			err = replaceCategories(ctx, tx, id, row.Categories)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = m.audit(ctx, tx, id, "import", before)
+		if err != nil {
+			return nil, err
+		}
+
+		if existingID > 0 {
+			err = m.productChanged(ctx, tx, id, stockBefore)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result := ImportResult{Line: row.Line, SKU: row.SKU, Status: "updated", ProductID: id}
+		if existingID == 0 {
+			result.Status = "created"
+			if dryRun {
+				// the id only existed in the rolled back transaction
+				result.ProductID = 0
+			}
+		}
+		results = append(results, result)
+	}
+
+	if dryRun {
+		return results, nil
+	}
+
+	return results, tx.Commit()
+}
+
+// QueueImageImports queues images to be downloaded into the gallery of a product by the
+// background job, and returns how many were queued. Images already waiting are not queued twice.
+func (m *DBModel) QueueImageImports(productID int, sources []string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into image_imports (product_id, source, actor_id, request_id, created_at) values ($1, $2, $3, $4, $5)
+			on conflict (product_id, source) where done_at is null do nothing`
+
+	queued := 0
+	for _, src := range sources {
+		res, err := m.DB.ExecContext(ctx, stmt, productID, src, nullID(m.actorID), m.requestID, time.Now())
+		if err != nil {
+			return queued, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return queued, err
+		}
+		queued += int(n)
+	}
+
+	return queued, nil
+}
+
+// QueuedImageImports returns the images waiting to be downloaded, oldest first
+func (m *DBModel) QueuedImageImports(limit int) ([]*ImageImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, product_id, source, coalesce(actor_id, 0), request_id
+			from image_imports where done_at is null order by id limit $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []*ImageImport{}
+
+	for rows.Next() {
+		var i ImageImport
+		err := rows.Scan(&i.ID, &i.ProductID, &i.Source, &i.ActorID, &i.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, &i)
+	}
+
+	return imports, rows.Err()
+}
+
+// ImageImportDone takes an image off the queue, noting why it could not be imported if it failed
+func (m *DBModel) ImageImportDone(id int, failure string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `update image_imports set done_at = $1, error = $2 where id = $3`, time.Now(), failure, id)
+}
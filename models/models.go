@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
@@ -9,25 +10,211 @@ type Models struct {
 	DB DBModel
 }
 
+// Store holds the settings that apply to the whole shop
+type Store struct {
+	// Currency is the ISO 4217 code of the currency prices are stored and charged in
+	Currency string
+	// Country is the ISO 3166 code of the country the store is in, assumed for addresses without one
+	Country string
+	// PricesIncludeTax tells whether product prices already include tax, or have it added at checkout
+	PricesIncludeTax bool
+}
+
 // NewModels returns models with db pool
-func NewModels(db *sql.DB) Models {
+func NewModels(db *sql.DB, store Store) Models {
 	return Models{
-		DB: DBModel{DB: db},
+		DB: DBModel{DB: db, store: store},
 	}
 }
 
 type Product struct {
-	ID              int            `json:"id"`
-	Title           string         `json:"title"`
-	Price           int            `json:"price"`
-	Size            []string       `json:"size"`
-	Description     string         `json:"description"`
-	Image           string         `json:"image"`
-	Stock           int            `json:"stock"`
-	Shipping        bool           `json:"shipping"`
-	CreatedAt       time.Time      `json:"-"`
-	UpdatedAt       time.Time      `json:"-"`
-	ProductCategory map[int]string `json:"categories"`
+	ID             int             `json:"id"`
+	SKU            string          `json:"sku"`
+	Title          string          `json:"title"`
+	Price          Money           `json:"price"`
+	CompareAtPrice *Money          `json:"compare_at_price"`
+	SalePrice      *Money          `json:"sale_price"`
+	SaleStartsAt   *time.Time      `json:"sale_starts_at"`
+	SaleEndsAt     *time.Time      `json:"sale_ends_at"`
+	Pricing        Pricing         `json:"pricing"`
+	Display        *Pricing        `json:"display,omitempty"`
+	Size           []string        `json:"size"`
+	Description    string          `json:"description"`
+	Image          string          `json:"-"`
+	ImageURL       string          `json:"image"`
+	Stock          int             `json:"stock"`
+	Shipping       bool            `json:"shipping"`
+	TaxClass       string          `json:"tax_class"`
+	Weight         int             `json:"weight"`
+	Length         int             `json:"length"`
+	Width          int             `json:"width"`
+	Height         int             `json:"height"`
+	CreatedAt      time.Time       `json:"-"`
+	UpdatedAt      time.Time       `json:"-"`
+	DeletedAt      *time.Time      `json:"deleted_at,omitempty"`
+	Version        int             `json:"version"`
+	Categories     []CategoryRef   `json:"categories"`
+	Breadcrumbs    [][]CategoryRef `json:"breadcrumbs"`
+	Images         []*ProductImage `json:"images"`
+	Rating         Rating          `json:"rating"`
+}
+
+// Rating is the average rating of the approved reviews of a product, and how many there are
+type Rating struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Discount takes a percentage or a fixed amount off every product it is scoped to: one product,
+// or every product in a category and its subcategories
+type Discount struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Kind       string     `json:"kind"`
+	Value      Money      `json:"value"`
+	ProductID  int        `json:"product_id,omitempty"`
+	CategoryID int        `json:"category_id,omitempty"`
+	StartsAt   *time.Time `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at"`
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"-"`
+	UpdatedAt  time.Time  `json:"-"`
+}
+
+const (
+	CouponPercent      = "percent"
+	CouponFixed        = "fixed"
+	CouponFreeShipping = "free_shipping"
+)
+
+// Coupon is a promo code customers enter at checkout. It can be restricted to products and
+// categories, in which case only the matching cart lines are discounted. Value is the amount
+// fixed coupons take off, or the percentage of percent coupons, which has no currency.
+type Coupon struct {
+	ID           int        `json:"id"`
+	Code         string     `json:"code"`
+	Kind         string     `json:"kind"`
+	Value        Money      `json:"value"`
+	MinOrder     Money      `json:"min_order"`
+	ProductIDs   []int      `json:"product_ids"`
+	CategoryIDs  []int      `json:"category_ids"`
+	UsageLimit   *int       `json:"usage_limit"`
+	PerUserLimit *int       `json:"per_user_limit"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	Active       bool       `json:"active"`
+	Redemptions  int        `json:"redemptions"`
+	CreatedAt    time.Time  `json:"-"`
+	UpdatedAt    time.Time  `json:"-"`
+}
+
+// CouponResult is what a coupon does to a cart
+type CouponResult struct {
+	Code         string `json:"code"`
+	Subtotal     Money  `json:"subtotal"`
+	Discount     Money  `json:"discount"`
+	FreeShipping bool   `json:"free_shipping"`
+	Total        Money  `json:"total"`
+}
+
+// ExchangeRate is how many units of a currency one unit of the store currency is worth.
+// Rates are only used to show prices in other currencies; orders are charged in the store currency.
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      float64   `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TaxRate is a tax charged on products of a tax class, for addresses in a country and optionally
+// a postal code area or city. Rate is a percentage.
+type TaxRate struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Country      string    `json:"country"`
+	PostalPrefix string    `json:"postal_prefix"`
+	City         string    `json:"city"`
+	TaxClass     string    `json:"tax_class"`
+	Rate         float64   `json:"rate"`
+	CreatedAt    time.Time `json:"-"`
+	UpdatedAt    time.Time `json:"-"`
+}
+
+// TaxLine is one tax charged on an order, such as a state and a city sales tax
+type TaxLine struct {
+	Name   string  `json:"name"`
+	Rate   float64 `json:"rate"`
+	Amount Money   `json:"amount"`
+}
+
+const (
+	ShippingFlat   = "flat"
+	ShippingWeight = "weight"
+)
+
+// ShippingZone is an area shipped to: a list of countries, optionally narrowed to postal codes
+// starting with one of PostalPrefixes
+type ShippingZone struct {
+	ID             int               `json:"id"`
+	Name           string            `json:"name"`
+	Countries      []string          `json:"countries"`
+	PostalPrefixes []string          `json:"postal_prefixes"`
+	Methods        []*ShippingMethod `json:"methods"`
+	CreatedAt      time.Time         `json:"-"`
+	UpdatedAt      time.Time         `json:"-"`
+}
+
+// ShippingMethod is a way of shipping to a zone. Flat methods cost Price; weight methods cost Price
+// plus RatePerKg for every started kilogram. Either is free when the order reaches FreeOver.
+type ShippingMethod struct {
+	ID        int       `json:"id"`
+	ZoneID    int       `json:"zone_id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Price     Money     `json:"price"`
+	RatePerKg Money     `json:"rate_per_kg"`
+	MaxWeight *int      `json:"max_weight"`
+	FreeOver  *Money    `json:"free_over"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// ShippingQuote is what shipping a cart with a method costs
+type ShippingQuote struct {
+	MethodID int    `json:"method_id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Cost     Money  `json:"cost"`
+}
+
+type ProductImage struct {
+	ID        int            `json:"id"`
+	ProductID int            `json:"-"`
+	Key       string         `json:"-"`
+	Source    string         `json:"-"`
+	URL       string         `json:"url"`
+	AltText   string         `json:"alt_text"`
+	SortOrder int            `json:"sort_order"`
+	Primary   bool           `json:"primary"`
+	Width     int            `json:"width"`
+	Height    int            `json:"height"`
+	Variants  []ImageVariant `json:"variants"`
+	CreatedAt time.Time      `json:"-"`
+	UpdatedAt time.Time      `json:"-"`
+}
+
+type ImageVariant struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
 }
 
 type Size struct {
@@ -39,10 +226,18 @@ type Size struct {
 }
 
 type Category struct {
-	ID           int       `json:"-"`
-	CategoryName string    `json:"category_name"`
-	CreatedAt    time.Time `json:"-"`
-	UpdatedAt    time.Time `json:"-"`
+	ID           int         `json:"id"`
+	ParentID     int         `json:"parent_id,omitempty"`
+	CategoryName string      `json:"category_name"`
+	Children     []*Category `json:"children,omitempty"`
+	CreatedAt    time.Time   `json:"-"`
+	UpdatedAt    time.Time   `json:"-"`
+}
+
+// CategoryRef is a lightweight reference to a category, used for product categories and breadcrumbs
+type CategoryRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
 
 type ProductCategory struct {
@@ -70,13 +265,99 @@ type CartProducts struct {
 	ID          int         `json:"id"`
 	ProductID   []string    `json:"product_id"`
 	Size        []string    `json:"size"`
-	Price       []string    `json:"price"`
+	Price       []Money     `json:"price"`
 	Quantity    []string    `json:"quantity"`
 	UserID      int         `json:"-"`
-	Total       int         `json:"total"`
+	Total       Money       `json:"total"`
 	Status      string      `json:"status"`
 	BillingInfo BillingInfo `json:"billing_info"`
 	User        User        `json:"user_info"`
+
+	// Email is where guest orders, placed without an account, are confirmed. LookupToken is set
+	// when a guest order is created, for the guest to look the order up with; it is not stored.
+	Email       string `json:"email,omitempty"`
+	LookupToken string `json:"-"`
+
+	CouponCode   string `json:"coupon_code,omitempty"`
+	Discount     Money  `json:"discount"`
+	FreeShipping bool   `json:"free_shipping"`
+
+	ShippingMethodID int    `json:"shipping_method_id,omitempty"`
+	ShippingMethod   string `json:"shipping_method,omitempty"`
+	Shipping         Money  `json:"shipping"`
+
+	// Tax is the sum of TaxLines. It is part of Total either way; TaxIncluded tells whether it
+	// was already in the prices or added on top of them.
+	Tax         Money     `json:"tax"`
+	TaxLines    []TaxLine `json:"tax_lines"`
+	TaxIncluded bool      `json:"tax_included"`
+
+	Fulfillments []*Fulfillment `json:"fulfillments,omitempty"`
+
+	// taxClasses are the tax classes of the products, line by line, set when the cart is priced
+	taxClasses []string
+	// weight is the chargeable weight of the products that need shipping, in grams, and
+	// needsShipping whether there are any, set when the cart is priced
+	weight        int
+	needsShipping bool
+}
+
+const (
+	OrderPending          = "pending"
+	OrderPaid             = "paid"
+	OrderCancelled        = "cancelled"
+	OrderShipped          = "shipped"
+	OrderPartiallyShipped = "partially_shipped"
+	OrderDelivered        = "delivered"
+)
+
+// Fulfillment is a parcel sent for an order, holding some or all of its items
+type Fulfillment struct {
+	ID             int               `json:"id"`
+	OrderID        int               `json:"order_id"`
+	Items          []FulfillmentItem `json:"items"`
+	Carrier        string            `json:"carrier"`
+	TrackingNumber string            `json:"tracking_number"`
+	TrackingURL    string            `json:"tracking_url"`
+	ShippedAt      time.Time         `json:"shipped_at"`
+	CreatedAt      time.Time         `json:"-"`
+}
+
+// FulfillmentItem is a quantity of one order line sent in a fulfillment. Line is the index of the
+// line in the order; ProductID and Size are filled in from the order when read.
+type FulfillmentItem struct {
+	Line      int    `json:"line"`
+	ProductID string `json:"product_id"`
+	Size      string `json:"size"`
+	Quantity  int    `json:"quantity"`
+}
+
+// Cart is a shopping cart kept on the server, for a signed in user or for an anonymous cart token.
+// Prices, stock and totals are worked out every time it is read.
+type Cart struct {
+	ID        int         `json:"id"`
+	UserID    int         `json:"-"`
+	Token     string      `json:"token,omitempty"`
+	Email     string      `json:"email,omitempty"`
+	Items     []*CartItem `json:"items"`
+	Count     int         `json:"count"`
+	Subtotal  Money       `json:"subtotal"`
+	Available bool        `json:"available"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// CartItem is a product in a cart. Available is false when the product is archived or has
+// less stock than the quantity asked for; such items do not count towards the subtotal.
+type CartItem struct {
+	ProductID int       `json:"product_id"`
+	Size      string    `json:"size"`
+	Quantity  int       `json:"quantity"`
+	Title     string    `json:"title"`
+	Price     Money     `json:"price"`
+	LineTotal Money     `json:"line_total"`
+	Stock     int       `json:"stock"`
+	Available bool      `json:"available"`
+	AddedAt   time.Time `json:"added_at"`
 }
 
 type BillingInfo struct {
@@ -86,8 +367,123 @@ type BillingInfo struct {
 	Address    string    `json:"address"`
 	PostalCode string    `json:"postal_code"`
 	City       string    `json:"city"`
+	Country    string    `json:"country"`
 	UserID     int       `json:"user_id"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"-"`
 	OrderID    int       `json:"-"`
 }
+
+type AuditEntry struct {
+	ID        int             `json:"id"`
+	EntityID  int             `json:"entity_id"`
+	Action    string          `json:"action"`
+	ActorID   int             `json:"actor_id"`
+	RequestID string          `json:"request_id"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	Diff      json.RawMessage `json:"diff"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Wishlist is a named list of products a user saved for later. A list with a share token can be
+// seen by anyone who has the token.
+type Wishlist struct {
+	ID         int             `json:"id"`
+	UserID     int             `json:"-"`
+	Name       string          `json:"name"`
+	ShareToken string          `json:"share_token,omitempty"`
+	Items      []*WishlistItem `json:"items"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// WishlistItem is a product on a wishlist, with its price now and when it was added. BackInStock
+// is set when the product came back in stock after being sold out, and PriceDropped while it
+// costs less than when it was added.
+type WishlistItem struct {
+	ProductID    int       `json:"product_id"`
+	Size         string    `json:"size"`
+	Title        string    `json:"title"`
+	Price        Money     `json:"price"`
+	AddedPrice   Money     `json:"added_price"`
+	InStock      bool      `json:"in_stock"`
+	BackInStock  bool      `json:"back_in_stock"`
+	PriceDropped bool      `json:"price_dropped"`
+	AddedAt      time.Time `json:"added_at"`
+}
+
+// StockNotification is a request to be told by email when a sold out product is back in stock,
+// optionally in one size. It is queued when the product comes back, and sent from the queue.
+type StockNotification struct {
+	ID        int        `json:"id"`
+	ProductID int        `json:"product_id"`
+	Title     string     `json:"title"`
+	Size      string     `json:"size,omitempty"`
+	Email     string     `json:"email"`
+	CreatedAt time.Time  `json:"created_at"`
+	QueuedAt  *time.Time `json:"queued_at,omitempty"`
+}
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Review is a customer's rating of a product they received. Reviews are shown once approved.
+type Review struct {
+	ID           int        `json:"id"`
+	ProductID    int        `json:"product_id"`
+	UserID       int        `json:"-"`
+	Author       string     `json:"author"`
+	Rating       int        `json:"rating"`
+	Title        string     `json:"title"`
+	Body         string     `json:"body"`
+	Status       string     `json:"status"`
+	HelpfulCount int        `json:"helpful_count"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ModeratedAt  *time.Time `json:"moderated_at,omitempty"`
+}
+
+const (
+	RelatedPinned         = "pinned"
+	RelatedBoughtTogether = "bought_together"
+	RelatedSameCategory   = "same_category"
+)
+
+// RelatedProduct is a product recommended alongside another. Source tells why: pinned by an
+// admin, bought together with it, or in the same category. Orders is how many orders held both.
+type RelatedProduct struct {
+	Product *Product `json:"product"`
+	Source  string   `json:"source"`
+	Orders  int      `json:"orders,omitempty"`
+}
+
+// RelatedOverrides are the related products an admin set by hand for a product. Pinned come
+// first, in order; hidden are never recommended with it.
+type RelatedOverrides struct {
+	ProductID int   `json:"product_id"`
+	Pinned    []int `json:"pinned"`
+	Hidden    []int `json:"hidden"`
+}
+
+// CartRecoveryStats tells how well reminders about abandoned carts bring customers back.
+// A cart is recovered when it is checked out after a reminder about it was sent.
+type CartRecoveryStats struct {
+	RemindersSent    int     `json:"reminders_sent"`
+	CartsReminded    int     `json:"carts_reminded"`
+	CartsRecovered   int     `json:"carts_recovered"`
+	RecoveryRate     float64 `json:"recovery_rate"`
+	RecoveredRevenue Money   `json:"recovered_revenue"`
+}
+
+// IdempotentResponse is the response stored for a request made with an idempotency key, to be
+// replayed when the request is retried. Status is 0 while the first request is still running.
+type IdempotentResponse struct {
+	RequestHash string
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+	CreatedAt   time.Time
+}
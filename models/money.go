@@ -0,0 +1,161 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in the minor unit of an ISO 4217 currency, such as cents for USD or yen for JPY
+type Money struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// ErrCurrencyMismatch is returned when an amount is given in another currency than the one expected
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// minorUnits lists the currencies that do not have two decimals
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// MinorUnits is the number of decimals of a currency
+func MinorUnits(currency string) int {
+	if n, ok := minorUnits[currency]; ok {
+		return n
+	}
+	return 2
+}
+
+// ValidCurrency tells whether code looks like an ISO 4217 currency code
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns the sum of two amounts in the same currency
+func (m Money) Add(o Money) Money {
+	m.Amount += o.Amount
+	return m
+}
+
+// Sub returns the difference of two amounts in the same currency
+func (m Money) Sub(o Money) Money {
+	m.Amount -= o.Amount
+	return m
+}
+
+// Mul returns the amount n times over, as for a quantity of items
+func (m Money) Mul(n int) Money {
+	m.Amount *= n
+	return m
+}
+
+// Percent returns p percent of the amount, rounded down
+func (m Money) Percent(p int) Money {
+	m.Amount = m.Amount * p / 100
+	return m
+}
+
+// Convert changes the amount into another currency, given how many units of that currency
+// one unit of this one is worth. The result is rounded to the minor unit.
+func (m Money) Convert(currency string, rate float64) Money {
+	major := float64(m.Amount) / math.Pow10(MinorUnits(m.Currency))
+	amount := math.Round(major * rate * math.Pow10(MinorUnits(currency)))
+
+	return Money{Amount: int(amount), Currency: currency}
+}
+
+// String formats the amount in major units, as in "12.50 EUR"
+func (m Money) String() string {
+	decimals := MinorUnits(m.Currency)
+	if decimals == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	unit := int(math.Pow10(decimals))
+
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, decimals, amount%unit, m.Currency)
+}
+
+// UnmarshalJSON accepts {"amount": 1250, "currency": "EUR"}, or a bare number of minor units
+// with the currency left empty for the caller to fill in
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if !strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		m.Currency = ""
+		return json.Unmarshal(data, &m.Amount)
+	}
+
+	type money Money
+	var v money
+
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	if v.Currency != "" && !ValidCurrency(v.Currency) {
+		return fmt.Errorf("invalid currency %q", v.Currency)
+	}
+
+	*m = Money(v)
+	return nil
+}
+
+// In checks that the amount is in the given currency, filling it in if it was left empty
+func (m *Money) In(currency string) error {
+	if m.Currency == "" {
+		m.Currency = currency
+	}
+	if m.Currency != currency {
+		return fmt.Errorf("%w: amounts must be in %s", ErrCurrencyMismatch, currency)
+	}
+	return nil
+}
+
+// Scan reads an amount stored as an integer. The database holds amounts in the store currency
+// only, so the currency is left for the model to fill in, see DBModel.Currency.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		m.Amount = int(v)
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		amount, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid amount %q", v)
+		}
+		m.Amount = amount
+	case nil:
+		m.Amount = 0
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	return nil
+}
+
+// Value stores the amount alone, in minor units
+func (m Money) Value() (driver.Value, error) {
+	return int64(m.Amount), nil
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	eur := func(amount int) Money { return Money{Amount: amount, Currency: "EUR"} }
+
+	tests := []struct {
+		name string
+		got  Money
+		want Money
+	}{
+		{"add", eur(1250).Add(eur(99)), eur(1349)},
+		{"sub", eur(1250).Sub(eur(1300)), eur(-50)},
+		{"mul", eur(1250).Mul(3), eur(3750)},
+		{"mul by zero", eur(1250).Mul(0), eur(0)},
+		{"percent", eur(1250).Percent(10), eur(125)},
+		{"percent rounds down", eur(999).Percent(15), eur(149)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     Money
+		currency string
+		rate     float64
+		want     Money
+	}{
+		{"two decimals", Money{Amount: 1000, Currency: "EUR"}, "USD", 1.0825, Money{Amount: 1083, Currency: "USD"}},
+		{"to no decimals", Money{Amount: 1000, Currency: "EUR"}, "JPY", 161.2, Money{Amount: 1612, Currency: "JPY"}},
+		{"from no decimals", Money{Amount: 1612, Currency: "JPY"}, "EUR", 0.0062, Money{Amount: 999, Currency: "EUR"}},
+		{"to three decimals", Money{Amount: 1000, Currency: "USD"}, "KWD", 0.307, Money{Amount: 3070, Currency: "KWD"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.from.Convert(tt.currency, tt.rate)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{Amount: 1250, Currency: "EUR"}, "12.50 EUR"},
+		{Money{Amount: 5, Currency: "EUR"}, "0.05 EUR"},
+		{Money{Amount: -1250, Currency: "EUR"}, "-12.50 EUR"},
+		{Money{Amount: 1250, Currency: "JPY"}, "1250 JPY"},
+		{Money{Amount: 1250, Currency: "KWD"}, "1.250 KWD"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.money.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Money
+		wantErr bool
+	}{
+		{"object", `{"amount": 1250, "currency": "EUR"}`, Money{Amount: 1250, Currency: "EUR"}, false},
+		{"bare number", `1250`, Money{Amount: 1250}, false},
+		{"object without currency", `{"amount": 1250}`, Money{Amount: 1250}, false},
+		{"invalid currency", `{"amount": 1250, "currency": "euro"}`, Money{}, true},
+		{"not a number", `"12.50"`, Money{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyIn(t *testing.T) {
+	tests := []struct {
+		name    string
+		money   Money
+		want    Money
+		wantErr error
+	}{
+		{"same currency", Money{Amount: 100, Currency: "EUR"}, Money{Amount: 100, Currency: "EUR"}, nil},
+		{"currency filled in", Money{Amount: 100}, Money{Amount: 100, Currency: "EUR"}, nil},
+		{"other currency", Money{Amount: 100, Currency: "USD"}, Money{Amount: 100, Currency: "USD"}, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.money.In("EUR")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if tt.money != tt.want {
+				t.Errorf("got %v, want %v", tt.money, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    int
+		wantErr bool
+	}{
+		{"integer", int64(1250), 1250, false},
+		{"text", "1250", 1250, false},
+		{"bytes", []byte("-50"), -50, false},
+		{"null", nil, 0, false},
+		{"not a number", "12.50", 0, true},
+		{"other type", 12.5, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Money{Amount: 7}
+			err := m.Scan(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && m.Amount != tt.want {
+				t.Errorf("got %d, want %d", m.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidCurrency(t *testing.T) {
+	tests := map[string]bool{
+		"EUR":  true,
+		"JPY":  true,
+		"eur":  false,
+		"EU":   false,
+		"EURO": false,
+		"E1R":  false,
+		"":     false,
+	}
+
+	for code, want := range tests {
+		if got := ValidCurrency(code); got != want {
+			t.Errorf("ValidCurrency(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"time"
+)
+
+// Pricing is what a product costs at a given moment, after sale prices and discounts
+type Pricing struct {
+	// Price is the effective price, the one charged at checkout
+	Price Money `json:"price"`
+	// CompareAt is the price to show struck through next to Price, if the product is reduced
+	CompareAt *Money           `json:"compare_at,omitempty"`
+	OnSale    bool             `json:"on_sale"`
+	Discount  *AppliedDiscount `json:"discount,omitempty"`
+}
+
+// AppliedDiscount is the discount that went into a price, and how much it took off
+type AppliedDiscount struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Value  Money  `json:"value"`
+	Amount Money  `json:"amount"`
+}
+
+// EffectivePrice is the single place prices are worked out. A running sale replaces the regular
+// price, then the applicable discount taking the most off is applied to that; discounts do not
+// stack. discounts are those scoped to the product or its categories, whether running or not.
+func EffectivePrice(p *Product, discounts []*Discount, now time.Time) Pricing {
+	pricing := Pricing{Price: p.Price}
+
+	if p.saleRunning(now) {
+		pricing.Price = *p.SalePrice
+		pricing.OnSale = true
+	}
+
+	for _, d := range discounts {
+		if !d.runningAt(now) {
+			continue
+		}
+
+		amount := d.amountOff(pricing.Price)
+		if amount.Amount > 0 && (pricing.Discount == nil || amount.Amount > pricing.Discount.Amount.Amount) {
+			pricing.Discount = &AppliedDiscount{
+				ID:     d.ID,
+				Name:   d.Name,
+				Kind:   d.Kind,
+				Value:  d.Value,
+				Amount: amount,
+			}
+		}
+	}
+	if pricing.Discount != nil {
+		pricing.Price = pricing.Price.Sub(pricing.Discount.Amount)
+	}
+
+	regular := p.Price
+	if p.CompareAtPrice != nil && p.CompareAtPrice.Amount > regular.Amount {
+		regular = *p.CompareAtPrice
+	}
+	if pricing.Price.Amount < regular.Amount {
+		pricing.CompareAt = &regular
+	}
+
+	return pricing
+}
+
+func (p *Product) saleRunning(now time.Time) bool {
+	if p.SalePrice == nil {
+		return false
+	}
+
+	return within(now, p.SaleStartsAt, p.SaleEndsAt)
+}
+
+func (d *Discount) runningAt(now time.Time) bool {
+	return d.Active && within(now, d.StartsAt, d.EndsAt)
+}
+
+// amountOff is how much the discount takes off a price, never more than the price itself.
+// Fixed discounts are in the store currency, as prices are.
+func (d *Discount) amountOff(price Money) Money {
+	amount := Money{Currency: price.Currency}
+
+	switch d.Kind {
+	case DiscountPercent:
+		amount = price.Percent(d.Value.Amount)
+	case DiscountFixed:
+		amount = d.Value
+	}
+
+	if amount.Amount > price.Amount {
+		amount = price
+	}
+
+	return amount
+}
+
+// Convert returns the pricing in another currency, for display only
+func (p Pricing) Convert(currency string, rate float64) Pricing {
+	p.Price = p.Price.Convert(currency, rate)
+	if p.CompareAt != nil {
+		compareAt := p.CompareAt.Convert(currency, rate)
+		p.CompareAt = &compareAt
+	}
+	if p.Discount != nil {
+		discount := *p.Discount
+		discount.Amount = discount.Amount.Convert(currency, rate)
+		p.Discount = &discount
+	}
+
+	return p
+}
+
+// within tells whether t falls in the window [start, end), where a missing bound is open
+func within(t time.Time, start, end *time.Time) bool {
+	if start != nil && t.Before(*start) {
+		return false
+	}
+	if end != nil && !t.Before(*end) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEffectivePrice(t *testing.T) {
+	eur := func(amount int) Money { return Money{Amount: amount, Currency: "EUR"} }
+	ptr := func(m Money) *Money { return &m }
+
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	tests := []struct {
+		name         string
+		product      Product
+		discounts    []*Discount
+		want         Money
+		wantCompare  *Money
+		wantOnSale   bool
+		wantDiscount int
+	}{
+		{
+			name:    "regular price",
+			product: Product{Price: eur(1000)},
+			want:    eur(1000),
+		},
+		{
+			name:        "compare at price",
+			product:     Product{Price: eur(1000), CompareAtPrice: ptr(eur(1500))},
+			want:        eur(1000),
+			wantCompare: ptr(eur(1500)),
+		},
+		{
+			name:        "running sale",
+			product:     Product{Price: eur(1000), SalePrice: ptr(eur(800)), SaleStartsAt: &yesterday, SaleEndsAt: &tomorrow},
+			want:        eur(800),
+			wantCompare: ptr(eur(1000)),
+			wantOnSale:  true,
+		},
+		{
+			name:    "sale not started",
+			product: Product{Price: eur(1000), SalePrice: ptr(eur(800)), SaleStartsAt: &tomorrow},
+			want:    eur(1000),
+		},
+		{
+			name:    "sale ended",
+			product: Product{Price: eur(1000), SalePrice: ptr(eur(800)), SaleEndsAt: &now},
+			want:    eur(1000),
+		},
+		{
+			name:        "sale struck through against compare at price",
+			product:     Product{Price: eur(1000), CompareAtPrice: ptr(eur(1500)), SalePrice: ptr(eur(800))},
+			want:        eur(800),
+			wantCompare: ptr(eur(1500)),
+			wantOnSale:  true,
+		},
+		{
+			name:    "percent discount",
+			product: Product{Price: eur(1000)},
+			discounts: []*Discount{
+				{ID: 1, Kind: DiscountPercent, Value: Money{Amount: 10}, Active: true},
+			},
+			want:         eur(900),
+			wantCompare:  ptr(eur(1000)),
+			wantDiscount: 1,
+		},
+		{
+			name:    "largest discount wins",
+			product: Product{Price: eur(1000)},
+			discounts: []*Discount{
+				{ID: 1, Kind: DiscountPercent, Value: Money{Amount: 10}, Active: true},
+				{ID: 2, Kind: DiscountFixed, Value: eur(250), Active: true},
+				{ID: 3, Kind: DiscountPercent, Value: Money{Amount: 20}, Active: true},
+			},
+			want:         eur(750),
+			wantCompare:  ptr(eur(1000)),
+			wantDiscount: 2,
+		},
+		{
+			name:    "discount applies to the sale price",
+			product: Product{Price: eur(1000), SalePrice: ptr(eur(800))},
+			discounts: []*Discount{
+				{ID: 1, Kind: DiscountPercent, Value: Money{Amount: 50}, Active: true},
+			},
+			want:         eur(400),
+			wantCompare:  ptr(eur(1000)),
+			wantOnSale:   true,
+			wantDiscount: 1,
+		},
+		{
+			name:    "fixed discount capped at the price",
+			product: Product{Price: eur(1000)},
+			discounts: []*Discount{
+				{ID: 1, Kind: DiscountFixed, Value: eur(5000), Active: true},
+			},
+			want:         eur(0),
+			wantCompare:  ptr(eur(1000)),
+			wantDiscount: 1,
+		},
+		{
+			name:    "inactive and expired discounts ignored",
+			product: Product{Price: eur(1000)},
+			discounts: []*Discount{
+				{ID: 1, Kind: DiscountPercent, Value: Money{Amount: 10}},
+				{ID: 2, Kind: DiscountPercent, Value: Money{Amount: 20}, Active: true, EndsAt: &yesterday},
+				{ID: 3, Kind: DiscountPercent, Value: Money{Amount: 30}, Active: true, StartsAt: &tomorrow},
+			},
+			want: eur(1000),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EffectivePrice(&tt.product, tt.discounts, now)
+
+			if got.Price != tt.want {
+				t.Errorf("price %v, want %v", got.Price, tt.want)
+			}
+			if got.OnSale != tt.wantOnSale {
+				t.Errorf("on sale %t, want %t", got.OnSale, tt.wantOnSale)
+			}
+			switch {
+			case tt.wantCompare == nil && got.CompareAt != nil:
+				t.Errorf("compare at %v, want none", *got.CompareAt)
+			case tt.wantCompare != nil && (got.CompareAt == nil || *got.CompareAt != *tt.wantCompare):
+				t.Errorf("compare at %v, want %v", got.CompareAt, *tt.wantCompare)
+			}
+			switch {
+			case tt.wantDiscount == 0 && got.Discount != nil:
+				t.Errorf("discount %d applied, want none", got.Discount.ID)
+			case tt.wantDiscount != 0 && (got.Discount == nil || got.Discount.ID != tt.wantDiscount):
+				t.Errorf("discount %v applied, want %d", got.Discount, tt.wantDiscount)
+			}
+		})
+	}
+}
@@ -3,31 +3,115 @@ package models
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/lib/pq"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type DBModel struct {
 	DB *sql.DB
+
+	// store holds the shop settings. Every amount in the database is in the store currency.
+	store Store
+
+	// actorID and requestID are recorded in the audit log, see WithActor
+	actorID   int
+	requestID string
+
+	// sort is the order products are listed in, see SortedBy
+	sort string
+}
+
+// productSorts are the orders products can be listed in, by name
+var productSorts = map[string]string{
+	"title": "title",
+	"rating": `coalesce((select average from product_ratings r where r.product_id = products.id), 0) desc,
+				coalesce((select count from product_ratings r where r.product_id = products.id), 0) desc, title`,
+}
+
+// ValidProductSort tells whether products can be listed in the named order
+func ValidProductSort(sort string) bool {
+	_, ok := productSorts[sort]
+	return ok
+}
+
+// SortedBy returns a copy of the model that lists products in the named order, title or rating.
+// Products are listed by title by default.
+func (m *DBModel) SortedBy(sort string) *DBModel {
+	c := *m
+	c.sort = sort
+	return &c
 }
 
-// Get returns one product and error, if any
+// Currency returns the store currency
+func (m *DBModel) Currency() string {
+	return m.store.Currency
+}
+
+// ErrEditConflict is returned when a product has been changed by someone else since it was read
+var ErrEditConflict = errors.New("edit conflict")
+
+// ErrUnknownProduct is returned when an order refers to a product that does not exist or is archived
+var ErrUnknownProduct = errors.New("unknown product")
+
+// Get returns one product and error, if any. Archived products are not returned.
 func (m *DBModel) Get(id int) (*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, title, price, size, description, image, stock, shipping,
-				created_at, updated_at from products where id = $1
-	`
+	return m.get(ctx, id, false)
+}
+
+// GetWithArchived returns one product, even if it has been archived
+func (m *DBModel) GetWithArchived(id int) (*Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.get(ctx, id, true)
+}
+
+func (m *DBModel) get(ctx context.Context, id int, includeArchived bool) (*Product, error) {
+	query := `select ` + productColumns + ` from products where id = $1`
+	if !includeArchived {
+		query += " and deleted_at is null"
+	}
+
+	product, err := m.scanProduct(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.withRelations(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// productColumns are the products columns read by scanProduct, in order
+const productColumns = `id, coalesce(sku, ''), title, price, size, description, image, stock, shipping,
+				created_at, updated_at, deleted_at, version, compare_at_price, sale_price, sale_starts_at, sale_ends_at,
+				tax_class, weight, length, width, height,
+				coalesce((select average from product_ratings r where r.product_id = products.id), 0),
+				coalesce((select count from product_ratings r where r.product_id = products.id), 0)`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
+// scanProduct reads a row of productColumns, with prices in the store currency
+func (m *DBModel) scanProduct(row scanner) (*Product, error) {
 	var product Product
 
 	err := row.Scan(
 		&product.ID,
+		&product.SKU,
 		&product.Title,
 		&product.Price,
 		pq.Array(&product.Size),
@@ -37,57 +121,121 @@ func (m *DBModel) Get(id int) (*Product, error) {
 		&product.Shipping,
 		&product.CreatedAt,
 		&product.UpdatedAt,
+		&product.DeletedAt,
+		&product.Version,
+		&product.CompareAtPrice,
+		&product.SalePrice,
+		&product.SaleStartsAt,
+		&product.SaleEndsAt,
+		&product.TaxClass,
+		&product.Weight,
+		&product.Length,
+		&product.Width,
+		&product.Height,
+		&product.Rating.Average,
+		&product.Rating.Count,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	query = `select
-				pc.id, pc.product_id, pc.category_id, c.category_name
-			from
-				products_category pc
-				left join category c on (c.id = pc.category_id)
-			where
-				pc.product_id = $1
-	`
+	product.Price.Currency = m.store.Currency
+	if product.CompareAtPrice != nil {
+		product.CompareAtPrice.Currency = m.store.Currency
+	}
+	if product.SalePrice != nil {
+		product.SalePrice.Currency = m.store.Currency
+	}
 
-	rows, _ := m.DB.QueryContext(ctx, query, id)
-	defer rows.Close()
+	return &product, nil
+}
 
-	category := make(map[int]string)
-	for rows.Next() {
-		var pc ProductCategory
-		err := rows.Scan(
-			&pc.ID,
-			&pc.ProductID,
-			&pc.CategoryID,
-			&pc.Category.CategoryName,
-		)
-		if err != nil {
-			return nil, err
-		}
-		category[pc.ID] = pc.Category.CategoryName
+// withRelations loads the categories, breadcrumbs and images of a product, and works out its price
+func (m *DBModel) withRelations(ctx context.Context, product *Product) error {
+	discounts, err := m.productDiscounts(ctx, product.ID)
+	if err != nil {
+		return err
 	}
+	product.Pricing = EffectivePrice(product, discounts, time.Now())
 
-	product.ProductCategory = category
+	product.Categories, err = m.productCategories(ctx, product.ID)
+	if err != nil {
+		return err
+	}
 
-	return &product, nil
+	product.Breadcrumbs, err = m.breadcrumbs(ctx, product.ID)
+	if err != nil {
+		return err
+	}
+
+	product.Images, err = m.productImages(ctx, product.ID)
+	return err
+}
+
+// ProductFilter narrows the products listed by All to a category, or with Descendants to a
+// category and all the categories below it. The zero value lists every product.
+type ProductFilter struct {
+	CategoryID  int
+	Descendants bool
 }
 
-// All returns all products and error, if any
-func (m *DBModel) All(category ...int) ([]*Product, error) {
+// All returns the products matching the filter and error, if any. Archived products are not returned.
+func (m *DBModel) All(f ProductFilter) ([]*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	switch {
+	case f.CategoryID == 0:
+		return m.products(ctx, false, "")
+	case f.Descendants:
+		filter := `id in (
+				select product_id from products_category where category_id in (
+					with recursive tree as (
+						select id from category where id = $1
+						union
+						select c.id from category c join tree t on (c.parent_id = t.id)
+					)
+					select id from tree
+				)
+			)`
+		return m.products(ctx, false, filter, f.CategoryID)
+	default:
+		filter := "id in (select product_id from products_category where category_id = $1)"
+		return m.products(ctx, false, filter, f.CategoryID)
+	}
+}
+
+// AllWithArchived returns all products, including the archived ones
+func (m *DBModel) AllWithArchived() ([]*Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.products(ctx, true, "")
+}
+
+// products returns the products matching the filter condition, with their categories
+func (m *DBModel) products(ctx context.Context, includeArchived bool, filter string, args ...interface{}) ([]*Product, error) {
+	var conditions []string
+	if !includeArchived {
+		conditions = append(conditions, "deleted_at is null")
+	}
+	if filter != "" {
+		conditions = append(conditions, filter)
+	}
+
 	where := ""
-	if len(category) > 0 {
-		where = fmt.Sprintf("where id in (select product_id from products_category where category_id = %d)", category[0])
+	if len(conditions) > 0 {
+		where = "where " + strings.Join(conditions, " and ")
 	}
 
-	query := fmt.Sprintf(`select id, title, price, size, description, image, stock, shipping,
-				created_at, updated_at from products %s order by title`, where)
+	order, ok := productSorts[m.sort]
+	if !ok {
+		order = productSorts["title"]
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	query := fmt.Sprintf(`select %s from products %s order by %s`, productColumns, where, order)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -96,67 +244,39 @@ func (m *DBModel) All(category ...int) ([]*Product, error) {
 	var products []*Product
 
 	for rows.Next() {
-		var product Product
-		err := rows.Scan(
-			&product.ID,
-			&product.Title,
-			&product.Price,
-			pq.Array(&product.Size),
-			&product.Description,
-			&product.Image,
-			&product.Stock,
-			&product.Shipping,
-			&product.CreatedAt,
-			&product.UpdatedAt,
-		)
+		product, err := m.scanProduct(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		// get categories
-		categoryQuery := `select
-			pc.id, pc.product_id, pc.category_id, c.category_name
-		from
-			products_category pc
-			left join category c on (c.id = pc.category_id)
-		where
-			pc.product_id = $1
-		`
-
-		categoryRows, _ := m.DB.QueryContext(ctx, categoryQuery, product.ID)
-
-		categories := make(map[int]string)
-		for categoryRows.Next() {
-			var pc ProductCategory
-			err := categoryRows.Scan(
-				&pc.ID,
-				&pc.ProductID,
-				&pc.CategoryID,
-				&pc.Category.CategoryName,
-			)
-			if err != nil {
-				return nil, err
-			}
-			categories[pc.CategoryID] = pc.Category.CategoryName
+		err = m.withRelations(ctx, product)
+		if err != nil {
+			return nil, err
 		}
-		categoryRows.Close()
-
-		product.ProductCategory = categories
-		products = append(products, &product)
 
+		products = append(products, product)
 	}
-	return products, nil
+
+	return products, rows.Err()
 }
 
-func (m *DBModel) InsertProduct(product Product) (int, error) {
+// InsertProduct saves a new product, in the given categories, and returns its id
+func (m *DBModel) InsertProduct(product Product, categoryIDs []int) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `insert into products (title, price, size, description, image, stock, shipping, created_at, updated_at) 
-			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into products (title, price, size, description, image, stock, shipping, created_at, updated_at, sku,
+				compare_at_price, sale_price, sale_starts_at, sale_ends_at, tax_class, weight, length, width, height)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19) returning id`
 
 	var newID int
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		product.Title,
 		product.Price,
 		pq.Array(product.Size),
@@ -166,6 +286,16 @@ func (m *DBModel) InsertProduct(product Product) (int, error) {
 		product.Shipping,
 		time.Now(),
 		time.Now(),
+		nullString(product.SKU),
+		product.CompareAtPrice,
+		product.SalePrice,
+		utc(product.SaleStartsAt),
+		utc(product.SaleEndsAt),
+		taxClass(product.TaxClass),
+		product.Weight,
+		product.Length,
+		product.Width,
+		product.Height,
 	).Scan(&newID)
 
 	log.Println("New product ID:", newID)
@@ -173,38 +303,54 @@ func (m *DBModel) InsertProduct(product Product) (int, error) {
 		return 0, err
 	}
 
-	return newID, nil
+	err = checkCategories(ctx, tx, categoryIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	err = replaceCategories(ctx, tx, newID, categoryIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.audit(ctx, tx, newID, "insert", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
 }
 
-func (m *DBModel) InsertCategory(pc ProductCategory) error {
+// UpdateProduct saves a product, provided it is still at product.Version, and replaces its
+// categories unless categoryIDs is nil. The stored version is incremented, and ErrEditConflict is
+// returned if someone else changed the product in the meantime.
+func (m *DBModel) UpdateProduct(product Product, categoryIDs []int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `insert into products_category (product_id, category_id, created_at, updated_at)
-			values ($1, $2, $3, $4)`
-
-	_, err := m.DB.ExecContext(ctx, stmt,
-		pc.ProductID,
-		pc.CategoryID,
-		time.Now(),
-		time.Now(),
-	)
-
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
-}
+	before, err := productSnapshot(ctx, tx, product.ID)
+	if err != nil {
+		return err
+	}
 
-func (m *DBModel) UpdateProduct(product Product) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var stockBefore int
+	err = tx.QueryRowContext(ctx, `select stock from products where id = $1`, product.ID).Scan(&stockBefore)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
 
-	stmt := `update products set title = $1, price = $2, size = $3, description = $4, image = $5, stock = $6, shipping = $7, updated_at = $8 
-			where id = $9`
+	stmt := `update products set title = $1, price = $2, size = $3, description = $4, image = $5, stock = $6, shipping = $7, updated_at = $8,
+				sku = $11, compare_at_price = $12, sale_price = $13, sale_starts_at = $14, sale_ends_at = $15,
+				tax_class = $16, weight = $17, length = $18, width = $19, height = $20, version = version + 1
+			where id = $9 and version = $10`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	err = execOne(ctx, tx, stmt,
 		product.Title,
 		product.Price,
 		pq.Array(product.Size),
@@ -214,63 +360,278 @@ func (m *DBModel) UpdateProduct(product Product) error {
 		product.Shipping,
 		product.UpdatedAt,
 		product.ID,
+		product.Version,
+		nullString(product.SKU),
+		product.CompareAtPrice,
+		product.SalePrice,
+		utc(product.SaleStartsAt),
+		utc(product.SaleEndsAt),
+		taxClass(product.TaxClass),
+		product.Weight,
+		product.Length,
+		product.Width,
+		product.Height,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m.versionError(ctx, product.ID)
+	} else if err != nil {
+		return err
+	}
+
+	if categoryIDs != nil {
+		err = checkCategories(ctx, tx, categoryIDs)
+		if err != nil {
+			return err
+		}
+
+		err = replaceCategories(ctx, tx, product.ID, categoryIDs)
+		if err != nil {
+			return err
+		}
+	}
 
+	err = m.audit(ctx, tx, product.ID, "update", before)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = m.productChanged(ctx, tx, product.ID, stockBefore)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m *DBModel) UpdateCategory(pc ProductCategory) error {
+// productChanged lets what follows a product know that it changed, given the stock it had
+// before. It must run in the transaction that made the change.
+func (m *DBModel) productChanged(ctx context.Context, tx dbtx, id int, stockBefore int) error {
+	product, err := m.scanProduct(tx.QueryRowContext(ctx, `select `+productColumns+` from products where id = $1`, id))
+	if err != nil {
+		return err
+	}
+
+	err = flagWishlistItems(ctx, tx, product, stockBefore)
+	if err != nil {
+		return err
+	}
+
+	return queueStockNotifications(ctx, tx, product, stockBefore)
+}
+
+// ArchiveProduct hides a product from the shop, provided it is still at the given version.
+// Its row is kept for order history and can be restored.
+func (m *DBModel) ArchiveProduct(id, version int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `update products_category set category_id = $1, updated_at = $2
-			where product_id = $3`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		pc.CategoryID,
-		pc.UpdatedAt,
-		pc.ProductID,
-	)
+	before, err := productSnapshot(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update products set deleted_at = $1, updated_at = $1, version = version + 1
+			where id = $2 and version = $3 and deleted_at is null`
+
+	err = execOne(ctx, tx, stmt, time.Now(), id, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m.versionError(ctx, id, "deleted_at is null")
+	} else if err != nil {
+		return err
+	}
 
+	err = m.audit(ctx, tx, id, "archive", before)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (m *DBModel) DeleteProduct(id int) (string, error) {
+// RestoreProduct brings an archived product back into the shop
+func (m *DBModel) RestoreProduct(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var image string
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	query := `select image from products where id = $1`
+	before, err := productSnapshot(ctx, tx, id)
+	if err != nil {
+		return err
+	}
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	stmt := `update products set deleted_at = null, updated_at = $1, version = version + 1
+			where id = $2 and deleted_at is not null`
 
-	err := row.Scan(
-		&image,
-	)
+	err = execOne(ctx, tx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
 
-	stmt := `delete from products where id = $1`
+	err = m.audit(ctx, tx, id, "restore", before)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-	_, err = m.DB.ExecContext(ctx, stmt, id)
+// versionError tells why a versioned write matched no row: the product either does not exist
+// (sql.ErrNoRows) or is at another version (ErrEditConflict)
+func (m *DBModel) versionError(ctx context.Context, id int, conditions ...string) error {
+	query := "select exists(select 1 from products where id = $1"
+	for _, c := range conditions {
+		query += " and " + c
+	}
+	query += ")"
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&exists)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	return ErrEditConflict
+}
+
+// PurgeArchivedProducts permanently deletes products archived before the cutoff,
+// and returns the storage keys of their images
+func (m *DBModel) PurgeArchivedProducts(cutoff time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ids, err := scanIDs(m.DB.QueryContext(ctx, `select id from products where deleted_at < $1`, cutoff))
+	if err != nil {
+		return nil, err
 	}
-	return image, nil
+
+	var keys []string
+
+	for _, id := range ids {
+		productKeys, err := m.purgeProduct(ctx, id, cutoff)
+		if err != nil {
+			return keys, err
+		}
+
+		keys = append(keys, productKeys...)
+	}
+
+	return keys, nil
+}
+
+func (m *DBModel) purgeProduct(ctx context.Context, id int, cutoff time.Time) ([]string, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keys, err := productImageKeys(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := productSnapshot(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = execOne(ctx, tx, `delete from products where id = $1 and deleted_at < $2`, id, cutoff)
+	if errors.Is(err, sql.ErrNoRows) {
+		// restored in the meantime
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = m.audit(ctx, tx, id, "purge", before)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, tx.Commit()
+}
+
+// execOne runs a statement that must affect exactly one row, returning sql.ErrNoRows otherwise
+func execOne(ctx context.Context, db dbtx, stmt string, args ...interface{}) error {
+	res, err := db.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// nullString stores empty strings as NULL, for optional unique columns
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// taxClass stores products without a tax class in the standard one
+func taxClass(class string) string {
+	if class == "" {
+		return TaxClassStandard
+	}
+	return class
+}
+
+// utc stores optional timestamps in UTC, as timestamp columns drop the time zone
+func utc(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// scanIDs collects the single integer column of rows
+func scanIDs(rows *sql.Rows, err error) ([]int, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
 }
 
 func (m *DBModel) GetAllCategory() ([]*Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, category_name, created_at, updated_at
+	query := `select id, coalesce(parent_id, 0), category_name, created_at, updated_at
 			from category order by category_name`
 
 	rows, err := m.DB.QueryContext(ctx, query)
@@ -286,6 +647,7 @@ func (m *DBModel) GetAllCategory() ([]*Category, error) {
 
 		err := rows.Scan(
 			&c.ID,
+			&c.ParentID,
 			&c.CategoryName,
 			&c.CreatedAt,
 			&c.UpdatedAt,
@@ -375,70 +737,304 @@ func (m *DBModel) CheckEmail(email string) (string, error) {
 	return userEmail, nil
 }
 
-func (m *DBModel) CartOrders(cp CartProducts) (int, int, error) {
+// CartOrders creates an order from a cart. The prices the client sent are replaced by the
+// effective prices of the products, and the total is worked out from those. A coupon on the
+// cart is checked and redeemed in the same transaction that creates the order, and the cost of
+// the chosen shipping method is added. The ordered quantities are taken out of stock, failing with
+// ErrInsufficientStock if there are not enough. Tax is worked out for the billing address sent with the
+// cart, if any, and again once billing info is added. A cart without a user is a guest order:
+// its billing info is stored with it, and it gets a lookup token in cp.LookupToken.
+func (m *DBModel) CartOrders(cp *CartProducts) (int, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `insert into orders (product_id, product_size, product_price, quantity, user_id, total, status)
-			values ($1, $2, $3, $4, $5, $6, $7) returning user_id, id`
+	err := m.priceCart(ctx, cp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	err = m.takeStock(ctx, tx, cp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if cp.CouponCode != "" {
+		coupon, err := m.applyCoupon(ctx, tx, cp, cp.CouponCode, true)
+		if err != nil {
+			return 0, 0, err
+		}
+		cp.CouponCode = coupon.Code
+		cp.Discount = coupon.Discount
+		cp.FreeShipping = coupon.FreeShipping
+		cp.Total = coupon.Total
+	}
+
+	err = m.applyShipping(ctx, tx, cp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = m.applyTax(ctx, tx, cp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	taxLines, err := json.Marshal(cp.TaxLines)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// guest orders are looked up with a token mailed to the guest; only its hash is kept
+	var lookupTokenHash interface{}
+	if cp.UserID == 0 {
+		cp.LookupToken, err = newLookupToken()
+		if err != nil {
+			return 0, 0, err
+		}
+		lookupTokenHash = hashToken(cp.LookupToken)
+	}
+
+	cp.Status = OrderPending
+
+	stmt := `insert into orders (product_id, product_size, product_price, quantity, user_id, total, status,
+				coupon_code, discount, free_shipping, tax, tax_lines, tax_included, shipping_method_id, shipping_method, shipping,
+				email, lookup_token_hash)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+			returning coalesce(user_id, 0), id`
 
 	var userID int
 	var orderID int
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		pq.Array(cp.ProductID),
 		pq.Array(cp.Size),
 		pq.Array(cp.Price),
 		pq.Array(cp.Quantity),
-		cp.UserID,
+		nullID(cp.UserID),
 		cp.Total,
 		cp.Status,
+		nullString(cp.CouponCode),
+		cp.Discount,
+		cp.FreeShipping,
+		cp.Tax,
+		taxLines,
+		cp.TaxIncluded,
+		nullID(cp.ShippingMethodID),
+		nullString(cp.ShippingMethod),
+		cp.Shipping,
+		nullString(cp.Email),
+		lookupTokenHash,
 	).Scan(&userID, &orderID)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	return userID, orderID, nil
+	if cp.UserID == 0 {
+		b := cp.BillingInfo
+		b.OrderID = orderID
+		err = insertBillingInfo(ctx, tx, b)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if cp.CouponCode != "" {
+		err = redeemCoupon(ctx, tx, cp.CouponCode, orderID, cp.UserID, cp.Email, cp.Discount.Amount)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return userID, orderID, tx.Commit()
+}
+
+// takeStock checks the lines of a priced cart against the sizes and stock of their products, and
+// takes the quantities out of stock. The product rows stay locked until the order is committed, so
+// that concurrent orders cannot both sell the last unit.
+func (m *DBModel) takeStock(ctx context.Context, tx dbtx, cp *CartProducts) error {
+	var ids []int
+	wanted := make(map[int]int)
+	for i, productID := range cp.ProductID {
+		id, _ := strconv.Atoi(productID)
+		if _, ok := wanted[id]; !ok {
+			ids = append(ids, id)
+		}
+		wanted[id] += cp.quantity(i)
+	}
+
+	// lock in id order, so that orders for the same products do not deadlock
+	_, err := tx.ExecContext(ctx, `select id from products where id = any($1) order by id for update`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for i, productID := range cp.ProductID {
+		id, _ := strconv.Atoi(productID)
+		err = m.checkCartItem(ctx, tx, id, cp.Size[i], wanted[id])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		err = execOne(ctx, tx, `update products set stock = stock - $1, version = version + 1 where id = $2`, wanted[id], id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// priceCart sets the price of every cart line to the effective price of its product, and the total to match.
+// It also notes the tax class of every line and the weight of the products that ship.
+func (m *DBModel) priceCart(ctx context.Context, cp *CartProducts) error {
+	now := time.Now()
+	total := Money{Currency: m.store.Currency}
+	cp.Price = make([]Money, len(cp.ProductID))
+	cp.taxClasses = make([]string, len(cp.ProductID))
+	cp.weight = 0
+	cp.needsShipping = false
+
+	for i, productID := range cp.ProductID {
+		id, err := strconv.Atoi(productID)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrUnknownProduct, productID)
+		}
+
+		quantity, err := strconv.Atoi(cp.Quantity[i])
+		if err != nil || quantity < 1 {
+			return fmt.Errorf("invalid quantity %q for product %d", cp.Quantity[i], id)
+		}
+
+		product, err := m.scanProduct(m.DB.QueryRowContext(ctx,
+			`select `+productColumns+` from products where id = $1 and deleted_at is null`, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, id)
+		} else if err != nil {
+			return err
+		}
+
+		discounts, err := m.productDiscounts(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		price := EffectivePrice(product, discounts, now).Price
+		cp.Price[i] = price
+		cp.taxClasses[i] = product.TaxClass
+		if product.Shipping {
+			cp.weight += chargeableWeight(product) * quantity
+			cp.needsShipping = true
+		}
+		total = total.Add(price.Mul(quantity))
+	}
+
+	cp.Total = total
+	cp.Discount = Money{Currency: m.store.Currency}
+	return nil
+}
+
+// quantity returns the quantity of cart line i, checked when the cart was priced
+func (cp *CartProducts) quantity(i int) int {
+	n, _ := strconv.Atoi(cp.Quantity[i])
+	return n
 }
 
+// ErrOrderNotPending is returned when an order can no longer be changed, as it was paid or cancelled
+var ErrOrderNotPending = errors.New("order is no longer pending")
+
+// BillingInfo adds the billing address of a pending order of b.UserID, and works the shipping and
+// tax on the order out again for it. Orders of other users are not found.
 func (m *DBModel) BillingInfo(b BillingInfo) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `insert into billing_info (name, phone, address, postal_code, city, user_id, created_at, updated_at, order_id)
-			values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	var status string
+	err = tx.QueryRowContext(ctx, `select status from orders where id = $1 and user_id = $2 for update`,
+		b.OrderID, b.UserID).Scan(&status)
+	if err != nil {
+		return err
+	}
+	if status != OrderPending {
+		return fmt.Errorf("%w: %s", ErrOrderNotPending, status)
+	}
+
+	err = insertBillingInfo(ctx, tx, b)
+	if err != nil {
+		return err
+	}
+
+	err = m.repriceOrder(ctx, tx, b)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertBillingInfo(ctx context.Context, db dbtx, b BillingInfo) error {
+	stmt := `insert into billing_info (name, phone, address, postal_code, city, country, user_id, created_at, updated_at, order_id)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := db.ExecContext(ctx, stmt,
 		b.Name,
 		b.Phone,
 		b.Address,
 		b.PostalCode,
 		b.City,
-		b.UserID,
+		nullString(strings.ToUpper(b.Country)),
+		nullID(b.UserID),
 		time.Now(),
 		time.Now(),
 		b.OrderID,
 	)
-	if err != nil {
-		return err
-	}
 
-	return nil
+	return err
 }
 
+// AllOrders returns every order with its billing info and customer
 func (m *DBModel) AllOrders() ([]*CartProducts, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	return m.orders(ctx, "")
+}
+
+// orders returns the orders matching the filter condition. Orders that have no billing info
+// yet come with an empty one.
+func (m *DBModel) orders(ctx context.Context, filter string, args ...interface{}) ([]*CartProducts, error) {
 	query := `select
-						o.id, o.product_id, o.product_size, o.product_price, o.quantity, o.total, o.status,
-						bi.name, bi.phone, bi.address, bi.postal_code, bi.city, bi.user_id, bi.created_at,
-						u.first_name, u.last_name, u.phone, u.email
+						o.id, o.product_id, o.product_size, o.product_price, o.quantity, o.total, o.status, coalesce(o.user_id, 0), coalesce(o.email, ''),
+						coalesce(o.coupon_code, ''), o.discount, o.free_shipping, o.tax, o.tax_lines, o.tax_included,
+						coalesce(o.shipping_method_id, 0), coalesce(o.shipping_method, ''), o.shipping,
+						coalesce(bi.name, ''), coalesce(bi.phone, ''), coalesce(bi.address, ''), coalesce(bi.postal_code, ''),
+						coalesce(bi.city, ''), coalesce(bi.country, ''), coalesce(bi.user_id, 0), bi.created_at,
+						coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.phone, ''), coalesce(u.email, '')
 					from orders o
-					left join billing_info bi on (bi.order_id = o.id)
+					left join lateral (
+						select * from billing_info b where b.order_id = o.id
+						order by b.created_at desc, b.id desc limit 1
+					) bi on true
 					left join users u on (u.id = bi.user_id)`
+	if filter != "" {
+		query += " where " + filter
+	}
+	query += " order by o.id"
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -448,6 +1044,8 @@ func (m *DBModel) AllOrders() ([]*CartProducts, error) {
 
 	for rows.Next() {
 		var order CartProducts
+		var taxLines []byte
+		var billedAt sql.NullTime
 
 		err := rows.Scan(
 			&order.ID,
@@ -457,13 +1055,25 @@ func (m *DBModel) AllOrders() ([]*CartProducts, error) {
 			pq.Array(&order.Quantity),
 			&order.Total,
 			&order.Status,
+			&order.UserID,
+			&order.Email,
+			&order.CouponCode,
+			&order.Discount,
+			&order.FreeShipping,
+			&order.Tax,
+			&taxLines,
+			&order.TaxIncluded,
+			&order.ShippingMethodID,
+			&order.ShippingMethod,
+			&order.Shipping,
 			&order.BillingInfo.Name,
 			&order.BillingInfo.Phone,
 			&order.BillingInfo.Address,
 			&order.BillingInfo.PostalCode,
 			&order.BillingInfo.City,
+			&order.BillingInfo.Country,
 			&order.BillingInfo.UserID,
-			&order.BillingInfo.CreatedAt,
+			&billedAt,
 			&order.User.FirstName,
 			&order.User.LastName,
 			&order.User.Phone,
@@ -472,28 +1082,70 @@ func (m *DBModel) AllOrders() ([]*CartProducts, error) {
 		if err != nil {
 			return nil, err
 		}
+		order.BillingInfo.CreatedAt = billedAt.Time
+
+		err = json.Unmarshal(taxLines, &order.TaxLines)
+		if err != nil {
+			return nil, err
+		}
+
+		order.Total.Currency = m.store.Currency
+		order.Discount.Currency = m.store.Currency
+		order.Tax.Currency = m.store.Currency
+		order.Shipping.Currency = m.store.Currency
+		for i := range order.Price {
+			order.Price[i].Currency = m.store.Currency
+		}
 
 		orders = append(orders, &order)
 	}
 
-	return orders, nil
+	return orders, rows.Err()
 }
 
+// ErrStatusTransition is returned when an order cannot move from its status to the one asked for
+var ErrStatusTransition = errors.New("invalid order status change")
+
+// statusTransitions are the statuses an order may be moved to by hand, by status. Orders are
+// shipped by recording their fulfillments, and marked delivered once all of them arrived.
+var statusTransitions = map[string][]string{
+	OrderPending: {OrderPaid, OrderCancelled},
+	OrderPaid:    {OrderCancelled},
+	OrderShipped: {OrderDelivered},
+}
+
+// UpdateStatus moves an order to cp.Status, if its status allows it
 func (m *DBModel) UpdateStatus(cp CartProducts) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `update orders set status = $1
-			where id = $2`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `select status from orders where id = $1 for update`, cp.ID).Scan(&status)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		cp.Status,
-		cp.ID,
-	)
+	allowed := false
+	for _, next := range statusTransitions[status] {
+		if next == cp.Status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: a %s order cannot be moved to %s", ErrStatusTransition, status, cp.Status)
+	}
 
+	err = execOne(ctx, tx, `update orders set status = $1 where id = $2`, cp.Status, cp.ID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
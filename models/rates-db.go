@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AllExchangeRates returns the rates of every display currency, by currency code
+func (m *DBModel) AllExchangeRates() ([]*ExchangeRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanExchangeRates(m.DB.QueryContext(ctx, `select currency, rate, updated_at from exchange_rates order by currency`))
+}
+
+// GetExchangeRate returns the rate of one currency, or sql.ErrNoRows if prices cannot be shown in it
+func (m *DBModel) GetExchangeRate(currency string) (*ExchangeRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rates, err := scanExchangeRates(m.DB.QueryContext(ctx,
+		`select currency, rate, updated_at from exchange_rates where currency = $1`, currency))
+	if err != nil {
+		return nil, err
+	}
+	if len(rates) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return rates[0], nil
+}
+
+// SetExchangeRate adds or replaces the rate of a currency
+func (m *DBModel) SetExchangeRate(currency string, rate float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into exchange_rates (currency, rate, updated_at) values ($1, $2, $3)
+			on conflict (currency) do update set rate = excluded.rate, updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt, currency, rate, time.Now())
+	return err
+}
+
+func (m *DBModel) DeleteExchangeRate(currency string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `delete from exchange_rates where currency = $1`, currency)
+}
+
+func scanExchangeRates(rows *sql.Rows, err error) ([]*ExchangeRate, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*ExchangeRate{}
+
+	for rows.Next() {
+		var r ExchangeRate
+		err := rows.Scan(&r.Currency, &r.Rate, &r.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, &r)
+	}
+
+	return rates, rows.Err()
+}
@@ -0,0 +1,264 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// RelatedProducts returns up to limit products to recommend with a product: the ones an admin
+// pinned first, then the ones most often bought together with it, then others in its categories.
+// Archived products and the ones an admin hid are left out.
+func (m *DBModel) RelatedProducts(productID, limit int) ([]*RelatedProduct, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `select exists (select 1 from products where id = $1 and deleted_at is null)`,
+		productID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	var related []*RelatedProduct
+	seen := map[int]bool{productID: true}
+
+	sources := []struct {
+		source string
+		query  string
+	}{
+		{RelatedPinned, `select o.related_id, coalesce(c.orders, 0)
+				from related_product_overrides o
+				left join product_copurchases c on (c.product_id = o.product_id and c.related_id = o.related_id)
+				where o.product_id = $1 and not o.hidden
+				order by o.sort_order, o.related_id`},
+		{RelatedBoughtTogether, `select related_id, orders from product_copurchases
+				where product_id = $1
+				  and related_id not in (select related_id from related_product_overrides where product_id = $1 and hidden)
+				order by orders desc, related_id`},
+		{RelatedSameCategory, `select pc.product_id, 0 from products_category pc
+				where pc.category_id in (select category_id from products_category where product_id = $1)
+				  and pc.product_id not in (select related_id from related_product_overrides where product_id = $1 and hidden)
+				group by pc.product_id
+				order by count(*) desc, pc.product_id`},
+	}
+
+	for _, s := range sources {
+		if len(related) >= limit {
+			break
+		}
+
+		candidates, err := m.relatedCandidates(ctx, s.source, s.query+` limit $2`, productID, limit+len(seen))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range candidates {
+			if len(related) >= limit {
+				break
+			}
+			if seen[c.Product.ID] {
+				continue
+			}
+			seen[c.Product.ID] = true
+			related = append(related, c)
+		}
+	}
+
+	if related == nil {
+		related = []*RelatedProduct{}
+	}
+
+	return related, nil
+}
+
+// relatedCandidates runs a query returning product ids with the number of orders holding them,
+// and loads the products that are not archived, in the order of the query
+func (m *DBModel) relatedCandidates(ctx context.Context, source, query string, args ...interface{}) ([]*RelatedProduct, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []*RelatedProduct
+	var ids []int
+
+	for rows.Next() {
+		var id int
+		c := RelatedProduct{Source: source}
+		err := rows.Scan(&id, &c.Orders)
+		if err != nil {
+			return nil, err
+		}
+		c.Product = &Product{ID: id}
+		candidates = append(candidates, &c)
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	products, err := m.products(ctx, false, "id = any($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*Product, len(products))
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+
+	live := candidates[:0]
+	for _, c := range candidates {
+		if p, ok := byID[c.Product.ID]; ok {
+			c.Product = p
+			live = append(live, c)
+		}
+	}
+
+	return live, nil
+}
+
+// GetRelatedOverrides returns the related products an admin set by hand for a product
+func (m *DBModel) GetRelatedOverrides(productID int) (*RelatedOverrides, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `select exists (select 1 from products where id = $1)`, productID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select related_id, hidden from related_product_overrides
+			where product_id = $1 order by sort_order, related_id`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	o := RelatedOverrides{ProductID: productID, Pinned: []int{}, Hidden: []int{}}
+
+	for rows.Next() {
+		var id int
+		var hidden bool
+		err := rows.Scan(&id, &hidden)
+		if err != nil {
+			return nil, err
+		}
+		if hidden {
+			o.Hidden = append(o.Hidden, id)
+		} else {
+			o.Pinned = append(o.Pinned, id)
+		}
+	}
+
+	return &o, rows.Err()
+}
+
+// SetRelatedOverrides replaces the related products an admin set by hand for a product
+func (m *DBModel) SetRelatedOverrides(o RelatedOverrides) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `select exists (select 1 from products where id = $1)`, o.ProductID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from related_product_overrides where product_id = $1`, o.ProductID)
+	if err != nil {
+		return err
+	}
+
+	ids := append(append([]int{}, o.Pinned...), o.Hidden...)
+	for _, id := range ids {
+		err = tx.QueryRowContext(ctx, `select exists (select 1 from products where id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, id)
+		}
+	}
+
+	stmt := `insert into related_product_overrides (product_id, related_id, hidden, sort_order) values ($1, $2, $3, $4)`
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, stmt, o.ProductID, id, i >= len(o.Pinned), i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RefreshCopurchases works out again from all paid orders how often each pair of products was
+// bought together, and returns the number of pairs. Readers see the previous counts until it is done.
+func (m *DBModel) RefreshCopurchases() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from product_copurchases`)
+	if err != nil {
+		return 0, err
+	}
+
+	// order lines hold product ids as text, one line per size, and may refer to purged products
+	stmt := `insert into product_copurchases (product_id, related_id, orders)
+			with lines as (
+				select distinct o.id as order_id, p.id as product_id
+				from orders o
+				cross join unnest(o.product_id) as l(product_id)
+				join products p on (p.id::text = l.product_id)
+				where o.status = any($1)
+			)
+			select a.product_id, b.product_id, count(*)
+			from lines a
+			join lines b on (b.order_id = a.order_id and b.product_id <> a.product_id)
+			group by a.product_id, b.product_id`
+
+	paid := []string{OrderPaid, OrderPartiallyShipped, OrderShipped, OrderDelivered}
+
+	res, err := tx.ExecContext(ctx, stmt, pq.Array(paid))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
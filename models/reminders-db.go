@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// SetCartEmail notes the email of the owner of an anonymous cart, for reminders should the
+// cart be abandoned. Carts of signed in users use the email of the user.
+func (m *DBModel) SetCartEmail(owner CartOwner, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := cartID(ctx, tx, owner, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update carts set email = $1 where id = $2`, nullString(email), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AbandonedCarts returns the carts with items that have not changed since idleBefore and have
+// an email to remind them at, priced now. Carts already reminded maxReminders times, or reminded
+// after remindedBefore, are left out, as are emails that unsubscribed.
+func (m *DBModel) AbandonedCarts(idleBefore, remindedBefore time.Time, maxReminders int) ([]*Cart, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `select c.id, coalesce(u.email, c.email)
+			from carts c
+			left join users u on (u.id = c.user_id)
+			where c.updated_at < $1
+				and c.reminders_sent < $2
+				and (c.last_reminded_at is null or c.last_reminded_at < $3)
+				and coalesce(u.email, c.email, '') <> ''
+				and exists (select 1 from cart_items i where i.cart_id = c.id)
+				and not exists (select 1 from email_unsubscribes e where e.email = lower(coalesce(u.email, c.email)))
+			order by c.updated_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, idleBefore, maxReminders, remindedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	var emails []string
+
+	for rows.Next() {
+		var id int
+		var email string
+		err := rows.Scan(&id, &email)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		emails = append(emails, email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	carts := []*Cart{}
+	for i, id := range ids {
+		cart, err := m.cart(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		cart.Email = emails[i]
+		carts = append(carts, cart)
+	}
+
+	return carts, nil
+}
+
+// RecordCartReminder notes that a reminder about an abandoned cart was sent. It leaves the cart
+// as idle as it was.
+func (m *DBModel) RecordCartReminder(cartID int, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	_, err = tx.ExecContext(ctx, `insert into cart_reminders (cart_id, email, sent_at) values ($1, $2, $3)`, cartID, email, now)
+	if err != nil {
+		return err
+	}
+
+	err = execOne(ctx, tx, `update carts set reminders_sent = reminders_sent + 1, last_reminded_at = $1 where id = $2`, now, cartID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CartCheckedOut empties the owner's cart once an order was placed from it, so that it is not
+// taken for abandoned. Reminders sent about the cart are credited with the order.
+func (m *DBModel) CartCheckedOut(owner CartOwner, orderID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := cartID(ctx, tx, owner, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update cart_reminders set order_id = $1 where cart_id = $2 and order_id is null`, orderID, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from cart_items where cart_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = touchCart(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Unsubscribe stops reminders to an email
+func (m *DBModel) Unsubscribe(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `insert into email_unsubscribes (email, unsubscribed_at) values ($1, $2)
+			on conflict (email) do nothing`, strings.ToLower(email), time.Now())
+
+	return err
+}
+
+// CartRecoveryStats returns how many abandoned carts reminders brought back since a time
+func (m *DBModel) CartRecoveryStats(since time.Time) (*CartRecoveryStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `select count(*), count(distinct coalesce(r.cart_id, -r.id)), count(distinct r.order_id),
+				coalesce((select sum(o.total) from orders o where o.id in
+					(select order_id from cart_reminders where sent_at >= $1)), 0)
+			from cart_reminders r
+			where r.sent_at >= $1`
+
+	s := CartRecoveryStats{RecoveredRevenue: Money{Currency: m.store.Currency}}
+
+	err := m.DB.QueryRowContext(ctx, query, since).Scan(&s.RemindersSent, &s.CartsReminded, &s.CartsRecovered, &s.RecoveredRevenue)
+	if err != nil {
+		return nil, err
+	}
+	s.RecoveredRevenue.Currency = m.store.Currency
+
+	if s.CartsReminded > 0 {
+		s.RecoveryRate = float64(s.CartsRecovered) / float64(s.CartsReminded)
+	}
+
+	return &s, nil
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotPurchased is returned when a user reviews a product no delivered order of theirs holds
+	ErrNotPurchased = errors.New("only customers who received the product can review it")
+	// ErrReviewExists is returned when a user reviews a product they already reviewed
+	ErrReviewExists = errors.New("product already reviewed")
+)
+
+// reviewSorts are the orders approved reviews can be listed in, by name
+var reviewSorts = map[string]string{
+	"newest":  "rv.created_at desc, rv.id desc",
+	"helpful": "rv.helpful_count desc, rv.created_at desc, rv.id desc",
+	"rating":  "rv.rating desc, rv.created_at desc, rv.id desc",
+}
+
+// ValidReviewSort tells whether reviews can be listed in the named order
+func ValidReviewSort(sort string) bool {
+	_, ok := reviewSorts[sort]
+	return ok
+}
+
+// ProductReviews returns the approved reviews of a product in the named order, newest, helpful
+// or rating
+func (m *DBModel) ProductReviews(productID int, sort string) ([]*Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	order, ok := reviewSorts[sort]
+	if !ok {
+		order = reviewSorts["newest"]
+	}
+
+	return m.reviews(ctx, "rv.product_id = $1 and rv.status = $2", order, productID, ReviewApproved)
+}
+
+// ReviewsByStatus returns the reviews in a moderation state, oldest first, for moderators
+func (m *DBModel) ReviewsByStatus(status string) ([]*Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.reviews(ctx, "rv.status = $1", "rv.created_at, rv.id", status)
+}
+
+// GetReview returns one review, whatever its moderation state
+func (m *DBModel) GetReview(id int) (*Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	reviews, err := m.reviews(ctx, "rv.id = $1", "rv.id", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(reviews) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return reviews[0], nil
+}
+
+// InsertReview saves the review of a user, pending moderation. Only users with a delivered
+// order holding the product can review it, once.
+func (m *DBModel) InsertReview(r Review) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var purchased, reviewed bool
+	err = tx.QueryRowContext(ctx, `select
+				exists (select 1 from orders where user_id = $1 and status = $2 and $3 = any(product_id)),
+				exists (select 1 from reviews where user_id = $1 and product_id = $4)`,
+		r.UserID, OrderDelivered, fmt.Sprint(r.ProductID), r.ProductID).Scan(&purchased, &reviewed)
+	if err != nil {
+		return 0, err
+	}
+	if !purchased {
+		return 0, ErrNotPurchased
+	}
+	if reviewed {
+		return 0, ErrReviewExists
+	}
+
+	stmt := `insert into reviews (product_id, user_id, rating, title, body, status, created_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var id int
+	err = tx.QueryRowContext(ctx, stmt,
+		r.ProductID,
+		r.UserID,
+		r.Rating,
+		r.Title,
+		r.Body,
+		ReviewPending,
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// ModerateReview moves a review to a moderation state, and updates the rating of its product
+func (m *DBModel) ModerateReview(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var productID int
+	err = tx.QueryRowContext(ctx, `update reviews set status = $1, moderated_at = $2 where id = $3 returning product_id`,
+		status, time.Now(), id).Scan(&productID)
+	if err != nil {
+		return err
+	}
+
+	err = refreshRating(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// VoteReviewHelpful counts a user finding an approved review helpful, once per user, and returns
+// the helpful count of the review
+func (m *DBModel) VoteReviewHelpful(id, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(ctx, `select helpful_count from reviews where id = $1 and status = $2 for update`,
+		id, ReviewApproved).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `insert into review_votes (review_id, user_id, created_at) values ($1, $2, $3)
+			on conflict do nothing`, id, userID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	voted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if voted == 0 {
+		return count, nil
+	}
+
+	err = tx.QueryRowContext(ctx, `update reviews set helpful_count = helpful_count + 1 where id = $1 returning helpful_count`,
+		id).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+// refreshRating works the rating of a product out again from its approved reviews
+func refreshRating(ctx context.Context, tx dbtx, productID int) error {
+	stmt := `insert into product_ratings (product_id, average, count)
+				select $1, coalesce(avg(rating), 0), count(*) from reviews where product_id = $1 and status = $2
+			on conflict (product_id) do update set average = excluded.average, count = excluded.count`
+
+	_, err := tx.ExecContext(ctx, stmt, productID, ReviewApproved)
+	return err
+}
+
+// reviews returns the reviews matching the filter condition, in the order of the order clause,
+// which must be one of ours
+func (m *DBModel) reviews(ctx context.Context, filter, order string, args ...interface{}) ([]*Review, error) {
+	query := `select rv.id, rv.product_id, rv.user_id, trim(coalesce(u.first_name, '') || ' ' || left(coalesce(u.last_name, ''), 1)),
+				rv.rating, rv.title, rv.body, rv.status, rv.helpful_count, rv.created_at, rv.moderated_at
+			from reviews rv
+			left join users u on (u.id = rv.user_id)
+			where ` + filter + `
+			order by ` + order
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var r Review
+		err := rows.Scan(
+			&r.ID,
+			&r.ProductID,
+			&r.UserID,
+			&r.Author,
+			&r.Rating,
+			&r.Title,
+			&r.Body,
+			&r.Status,
+			&r.HelpfulCount,
+			&r.CreatedAt,
+			&r.ModeratedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, &r)
+	}
+
+	return reviews, rows.Err()
+}
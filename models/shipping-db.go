@@ -0,0 +1,377 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const shippingMethodColumns = `id, zone_id, name, kind, price, rate_per_kg, max_weight, free_over, active, created_at, updated_at`
+
+// AllShippingZones returns every shipping zone with its methods
+func (m *DBModel) AllShippingZones() ([]*ShippingZone, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.shippingZones(ctx, m.DB, false, "")
+}
+
+// GetShippingZone returns one shipping zone with its methods
+func (m *DBModel) GetShippingZone(id int) (*ShippingZone, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	zones, err := m.shippingZones(ctx, m.DB, false, "id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return zones[0], nil
+}
+
+func (m *DBModel) InsertShippingZone(z ShippingZone) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into shipping_zones (name, countries, postal_prefixes, created_at, updated_at)
+			values ($1, $2, $3, $4, $4) returning id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		z.Name,
+		pq.Array(upperAll(z.Countries)),
+		pq.Array(upperAll(z.PostalPrefixes)),
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+func (m *DBModel) UpdateShippingZone(z ShippingZone) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update shipping_zones set name = $1, countries = $2, postal_prefixes = $3, updated_at = $4 where id = $5`
+
+	return execOne(ctx, m.DB, stmt,
+		z.Name,
+		pq.Array(upperAll(z.Countries)),
+		pq.Array(upperAll(z.PostalPrefixes)),
+		time.Now(),
+		z.ID,
+	)
+}
+
+// DeleteShippingZone removes a zone and its methods. Orders keep the name and cost of their method.
+func (m *DBModel) DeleteShippingZone(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `delete from shipping_zones where id = $1`, id)
+}
+
+// GetShippingMethod returns one shipping method
+func (m *DBModel) GetShippingMethod(id int) (*ShippingMethod, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	methods, err := m.scanShippingMethods(m.DB.QueryContext(ctx,
+		`select `+shippingMethodColumns+` from shipping_methods where id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return methods[0], nil
+}
+
+func (m *DBModel) InsertShippingMethod(sm ShippingMethod) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into shipping_methods (zone_id, name, kind, price, rate_per_kg, max_weight, free_over, active,
+				created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9) returning id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		sm.ZoneID,
+		sm.Name,
+		sm.Kind,
+		sm.Price,
+		sm.RatePerKg,
+		sm.MaxWeight,
+		sm.FreeOver,
+		sm.Active,
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+func (m *DBModel) UpdateShippingMethod(sm ShippingMethod) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update shipping_methods set zone_id = $1, name = $2, kind = $3, price = $4, rate_per_kg = $5,
+				max_weight = $6, free_over = $7, active = $8, updated_at = $9
+			where id = $10`
+
+	return execOne(ctx, m.DB, stmt,
+		sm.ZoneID,
+		sm.Name,
+		sm.Kind,
+		sm.Price,
+		sm.RatePerKg,
+		sm.MaxWeight,
+		sm.FreeOver,
+		sm.Active,
+		time.Now(),
+		sm.ID,
+	)
+}
+
+func (m *DBModel) DeleteShippingMethod(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `delete from shipping_methods where id = $1`, id)
+}
+
+// ShippingQuotes prices a cart and tells what each shipping method available for its billing
+// address would cost. A coupon on the cart is taken into account, without being redeemed.
+func (m *DBModel) ShippingQuotes(cp *CartProducts) ([]*ShippingQuote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.priceCart(ctx, cp)
+	if err != nil {
+		return nil, err
+	}
+
+	if cp.CouponCode != "" {
+		coupon, err := m.applyCoupon(ctx, m.DB, cp, cp.CouponCode, false)
+		if err != nil {
+			return nil, err
+		}
+		cp.FreeShipping = coupon.FreeShipping
+		cp.Total = coupon.Total
+	}
+
+	return m.shippingQuotes(ctx, m.DB, cp)
+}
+
+// shippingQuotes returns the methods that can ship a priced cart to its billing address, cheapest
+// first. Nothing is returned if no product in the cart needs shipping.
+func (m *DBModel) shippingQuotes(ctx context.Context, db dbtx, cp *CartProducts) ([]*ShippingQuote, error) {
+	quotes := []*ShippingQuote{}
+	if !cp.needsShipping {
+		return quotes, nil
+	}
+
+	zones, err := m.shippingZones(ctx, db, true, "")
+	if err != nil {
+		return nil, err
+	}
+
+	zone := matchShippingZone(zones, m.country(cp.BillingInfo), cp.BillingInfo.PostalCode)
+	if zone == nil {
+		return quotes, nil
+	}
+
+	for _, method := range zone.Methods {
+		cost, ok := method.cost(cp.Total, cp.weight)
+		if !ok {
+			continue
+		}
+		if cp.FreeShipping {
+			cost.Amount = 0
+		}
+
+		quotes = append(quotes, &ShippingQuote{MethodID: method.ID, Name: method.Name, Kind: method.Kind, Cost: cost})
+	}
+
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].Cost.Amount < quotes[j].Cost.Amount
+	})
+
+	return quotes, nil
+}
+
+// applyShipping charges the shipping method chosen for a priced cart, adding its cost to the total.
+// Carts with nothing to ship are placed without shipping; carts with something to ship need a method.
+func (m *DBModel) applyShipping(ctx context.Context, db dbtx, cp *CartProducts) error {
+	cp.Shipping = Money{Currency: m.store.Currency}
+	if !cp.needsShipping {
+		cp.ShippingMethodID = 0
+		cp.ShippingMethod = ""
+		return nil
+	}
+	if cp.ShippingMethodID == 0 {
+		return fmt.Errorf("%w: the order has products to ship, choose a shipping method", ErrShippingUnavailable)
+	}
+
+	quotes, err := m.shippingQuotes(ctx, db, cp)
+	if err != nil {
+		return err
+	}
+
+	for _, q := range quotes {
+		if q.MethodID == cp.ShippingMethodID {
+			cp.ShippingMethod = q.Name
+			cp.Shipping = q.Cost
+			cp.Total = cp.Total.Add(q.Cost)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %d does not ship this order to this address", ErrShippingUnavailable, cp.ShippingMethodID)
+}
+
+// orderWeight notes the weight of the order lines that ship, and whether there are any, the way
+// priceCart does for carts. Archived products still count.
+func orderWeight(ctx context.Context, db dbtx, cp *CartProducts) error {
+	cp.weight = 0
+	cp.needsShipping = false
+
+	for i, productID := range cp.ProductID {
+		id, err := strconv.Atoi(productID)
+		if err != nil {
+			continue
+		}
+
+		var p Product
+		err = db.QueryRowContext(ctx, `select shipping, weight, length, width, height from products where id = $1`, id).Scan(
+			&p.Shipping, &p.Weight, &p.Length, &p.Width, &p.Height)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if p.Shipping {
+			cp.weight += chargeableWeight(&p) * cp.quantity(i)
+			cp.needsShipping = true
+		}
+	}
+
+	return nil
+}
+
+// country is the country of an address, or the store country if it gives none
+func (m *DBModel) country(b BillingInfo) string {
+	if b.Country != "" {
+		return strings.ToUpper(b.Country)
+	}
+	return m.store.Country
+}
+
+// shippingZones returns the zones matching the filter condition with their methods, active ones only with activeOnly
+func (m *DBModel) shippingZones(ctx context.Context, db dbtx, activeOnly bool, filter string, args ...interface{}) ([]*ShippingZone, error) {
+	query := `select id, name, countries, postal_prefixes, created_at, updated_at from shipping_zones`
+	if filter != "" {
+		query += " where " + filter
+	}
+	query += " order by name, id"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := []*ShippingZone{}
+
+	for rows.Next() {
+		var z ShippingZone
+		err := rows.Scan(
+			&z.ID,
+			&z.Name,
+			pq.Array(&z.Countries),
+			pq.Array(&z.PostalPrefixes),
+			&z.CreatedAt,
+			&z.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, &z)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	methodQuery := `select ` + shippingMethodColumns + ` from shipping_methods where zone_id = $1`
+	if activeOnly {
+		methodQuery += " and active"
+	}
+	methodQuery += " order by price, id"
+
+	for _, z := range zones {
+		z.Methods, err = m.scanShippingMethods(db.QueryContext(ctx, methodQuery, z.ID))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return zones, nil
+}
+
+// scanShippingMethods reads rows of shippingMethodColumns, with amounts in the store currency
+func (m *DBModel) scanShippingMethods(rows *sql.Rows, err error) ([]*ShippingMethod, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := []*ShippingMethod{}
+
+	for rows.Next() {
+		var sm ShippingMethod
+		err := rows.Scan(
+			&sm.ID,
+			&sm.ZoneID,
+			&sm.Name,
+			&sm.Kind,
+			&sm.Price,
+			&sm.RatePerKg,
+			&sm.MaxWeight,
+			&sm.FreeOver,
+			&sm.Active,
+			&sm.CreatedAt,
+			&sm.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sm.Price.Currency = m.store.Currency
+		sm.RatePerKg.Currency = m.store.Currency
+		if sm.FreeOver != nil {
+			sm.FreeOver.Currency = m.store.Currency
+		}
+		methods = append(methods, &sm)
+	}
+
+	return methods, rows.Err()
+}
+
+func upperAll(list []string) []string {
+	upper := []string{}
+	for _, s := range list {
+		upper = append(upper, strings.ToUpper(strings.ReplaceAll(s, " ", "")))
+	}
+	return upper
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrShippingUnavailable is returned when an order asks for a shipping method that cannot ship it,
+// or for none while it has products to ship
+var ErrShippingUnavailable = errors.New("shipping method not available")
+
+// volumetricDivisor turns a volume in cubic millimetres into a weight in grams, the way carriers
+// bill bulky parcels: 5000 cubic centimetres count as one kilogram
+const volumetricDivisor = 5000
+
+// chargeableWeight is what one unit of a product weighs for shipping, in grams: its weight, or its
+// volumetric weight if that is more
+func chargeableWeight(p *Product) int {
+	volumetric := p.Length * p.Width * p.Height / volumetricDivisor
+	if volumetric > p.Weight {
+		return volumetric
+	}
+	return p.Weight
+}
+
+// matchShippingZone returns the zone an address is in, or nil if it is not shipped to. Zones
+// narrowed to postal codes take precedence over whole countries, the longest prefix winning.
+func matchShippingZone(zones []*ShippingZone, country, postalCode string) *ShippingZone {
+	postalCode = strings.ToUpper(strings.ReplaceAll(postalCode, " ", ""))
+
+	var best *ShippingZone
+	bestPrefix := -1
+
+	for _, z := range zones {
+		if !containsFold(z.Countries, country) {
+			continue
+		}
+
+		prefix := -1
+		if len(z.PostalPrefixes) == 0 {
+			prefix = 0
+		}
+		for _, p := range z.PostalPrefixes {
+			if strings.HasPrefix(postalCode, strings.ToUpper(p)) && len(p) > prefix {
+				prefix = len(p)
+			}
+		}
+
+		if prefix > bestPrefix {
+			best, bestPrefix = z, prefix
+		}
+	}
+
+	return best
+}
+
+// cost returns what shipping weight grams with the method costs for an order of subtotal,
+// and false if the method does not take that weight
+func (sm *ShippingMethod) cost(subtotal Money, weight int) (Money, bool) {
+	if sm.MaxWeight != nil && weight > *sm.MaxWeight {
+		return Money{}, false
+	}
+	if sm.FreeOver != nil && subtotal.Amount >= sm.FreeOver.Amount {
+		return Money{Currency: sm.Price.Currency}, true
+	}
+
+	cost := sm.Price
+	if sm.Kind == ShippingWeight {
+		kilograms := (weight + 999) / 1000
+		cost = cost.Add(sm.RatePerKg.Mul(kilograms))
+	}
+
+	return cost, true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
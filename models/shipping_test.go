@@ -0,0 +1,92 @@
+package models
+
+import "testing"
+
+func TestChargeableWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		product Product
+		want    int
+	}{
+		{"weight", Product{Weight: 1200, Length: 100, Width: 100, Height: 100}, 1200},
+		{"volumetric", Product{Weight: 500, Length: 400, Width: 300, Height: 200}, 4800},
+		{"no dimensions", Product{Weight: 300}, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chargeableWeight(&tt.product); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchShippingZone(t *testing.T) {
+	france := &ShippingZone{ID: 1, Countries: []string{"FR", "MC"}}
+	corsica := &ShippingZone{ID: 2, Countries: []string{"FR"}, PostalPrefixes: []string{"20"}}
+	ajaccio := &ShippingZone{ID: 3, Countries: []string{"FR"}, PostalPrefixes: []string{"201"}}
+	london := &ShippingZone{ID: 4, Countries: []string{"GB"}, PostalPrefixes: []string{"sw", "ec"}}
+	zones := []*ShippingZone{france, corsica, ajaccio, london}
+
+	tests := []struct {
+		name       string
+		country    string
+		postalCode string
+		want       *ShippingZone
+	}{
+		{"whole country", "FR", "75001", france},
+		{"country ignores case", "mc", "98000", france},
+		{"prefix before country", "FR", "20200", corsica},
+		{"longest prefix", "FR", "20100", ajaccio},
+		{"prefix ignores case and spaces", "GB", "SW1A 1AA", london},
+		{"prefix not matched", "GB", "M1 1AE", nil},
+		{"country not shipped to", "DE", "10115", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchShippingZone(zones, tt.country, tt.postalCode)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShippingMethodCost(t *testing.T) {
+	eur := func(amount int) Money { return Money{Amount: amount, Currency: "EUR"} }
+	maxWeight := 5000
+	freeOver := eur(10000)
+
+	flat := &ShippingMethod{Kind: ShippingFlat, Price: eur(500)}
+	weight := &ShippingMethod{Kind: ShippingWeight, Price: eur(300), RatePerKg: eur(150), MaxWeight: &maxWeight}
+	free := &ShippingMethod{Kind: ShippingWeight, Price: eur(300), RatePerKg: eur(150), FreeOver: &freeOver}
+
+	tests := []struct {
+		name     string
+		method   *ShippingMethod
+		subtotal Money
+		weight   int
+		want     Money
+		wantOK   bool
+	}{
+		{"flat", flat, eur(2000), 12000, eur(500), true},
+		{"weight rounds up to the kilogram", weight, eur(2000), 1001, eur(600), true},
+		{"weight of whole kilograms", weight, eur(2000), 2000, eur(600), true},
+		{"nothing to weigh", weight, eur(2000), 0, eur(300), true},
+		{"at the max weight", weight, eur(2000), 5000, eur(1050), true},
+		{"over the max weight", weight, eur(2000), 5001, Money{}, false},
+		{"free over", free, eur(10000), 3000, eur(0), true},
+		{"under free over", free, eur(9999), 3000, eur(750), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.method.cost(tt.subtotal, tt.weight)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// ErrInStock is returned when asking to be told about a product coming back that is in stock
+var ErrInStock = errors.New("product is in stock")
+
+// SubscribeStock asks for an email when a sold out product is back in stock. Asking again for
+// the same product, size and email keeps the place in the queue.
+func (m *DBModel) SubscribeStock(productID int, size, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	product, err := m.scanProduct(m.DB.QueryRowContext(ctx,
+		`select `+productColumns+` from products where id = $1 and deleted_at is null`, productID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %d", ErrUnknownProduct, productID)
+	} else if err != nil {
+		return err
+	}
+
+	if size != "" && !containsFold(product.Size, size) {
+		return fmt.Errorf("%w: %d has no size %q", ErrUnknownProduct, productID, size)
+	}
+	if product.Stock > 0 {
+		return ErrInStock
+	}
+
+	stmt := `insert into stock_notifications (product_id, size, email, created_at) values ($1, $2, $3, $4)
+			on conflict (product_id, size, lower(email)) where sent_at is null do nothing`
+
+	_, err = m.DB.ExecContext(ctx, stmt, productID, size, email, time.Now())
+	return err
+}
+
+// QueuedStockNotifications returns notifications queued to be sent, oldest first
+func (m *DBModel) QueuedStockNotifications(limit int) ([]*StockNotification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select n.id, n.product_id, p.title, n.size, n.email, n.created_at, n.queued_at
+			from stock_notifications n
+			join products p on (p.id = n.product_id)
+			where n.queued_at is not null and n.sent_at is null
+			order by n.queued_at, n.id
+			limit $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*StockNotification{}
+
+	for rows.Next() {
+		var n StockNotification
+		err := rows.Scan(&n.ID, &n.ProductID, &n.Title, &n.Size, &n.Email, &n.CreatedAt, &n.QueuedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// StockNotificationSent takes a notification off the queue once it was sent
+func (m *DBModel) StockNotificationSent(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `update stock_notifications set sent_at = $1 where id = $2`, time.Now(), id)
+}
+
+// AdjustStock changes the stock of a product by delta, for goods received, counted or written
+// off, and returns the new stock. Stock cannot go below zero.
+func (m *DBModel) AdjustStock(productID, delta int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var stockBefore int
+	err = tx.QueryRowContext(ctx, `select stock from products where id = $1 for update`, productID).Scan(&stockBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	stock := stockBefore + delta
+	if stock < 0 {
+		return 0, fmt.Errorf("%w: %d of product %d left", ErrInsufficientStock, stockBefore, productID)
+	}
+
+	before, err := productSnapshot(ctx, tx, productID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = execOne(ctx, tx, `update products set stock = $1, updated_at = $2, version = version + 1 where id = $3`,
+		stock, time.Now(), productID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.audit(ctx, tx, productID, "adjust_stock", before)
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.productChanged(ctx, tx, productID, stockBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return stock, tx.Commit()
+}
+
+// queueStockNotifications queues the oldest waiting notifications for a product that was restocked,
+// so that no more customers are told than can buy: as many as there are units in stock, less those
+// queued and not sent yet, oldest first whatever the size. Sizes the product no longer comes in are
+// left waiting.
+func queueStockNotifications(ctx context.Context, tx dbtx, product *Product, stockBefore int) error {
+	if notificationsToQueue(product, stockBefore, 0) == 0 {
+		return nil
+	}
+
+	var queued int
+	err := tx.QueryRowContext(ctx, `select count(*) from stock_notifications
+			where product_id = $1 and queued_at is not null and sent_at is null`, product.ID).Scan(&queued)
+	if err != nil {
+		return err
+	}
+
+	n := notificationsToQueue(product, stockBefore, queued)
+	if n == 0 {
+		return nil
+	}
+
+	sizes := make([]string, len(product.Size))
+	for i, size := range product.Size {
+		sizes[i] = strings.ToLower(size)
+	}
+
+	stmt := `update stock_notifications set queued_at = $1
+			where id in (
+				select id from stock_notifications
+				where product_id = $2 and queued_at is null and sent_at is null
+					and (size = '' or lower(size) = any($4))
+				order by id
+				limit $3)`
+
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), product.ID, n, pq.Array(sizes))
+	return err
+}
+
+// notificationsToQueue is how many more notifications to queue for a product whose stock went from
+// stockBefore to product.Stock, with queued notifications already waiting to be sent
+func notificationsToQueue(product *Product, stockBefore, queued int) int {
+	if product.Stock <= stockBefore || product.DeletedAt != nil || product.Stock <= queued {
+		return 0
+	}
+
+	return product.Stock - queued
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotificationsToQueue(t *testing.T) {
+	archived := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		product     Product
+		stockBefore int
+		queued      int
+		want        int
+	}{
+		{"back in stock", Product{Stock: 3}, 0, 0, 3},
+		{"restocked while in stock", Product{Stock: 5}, 2, 0, 5},
+		{"some already queued", Product{Stock: 5}, 2, 2, 3},
+		{"as many queued as in stock", Product{Stock: 5}, 2, 5, 0},
+		{"more queued than in stock", Product{Stock: 2}, 1, 4, 0},
+		{"stock went down", Product{Stock: 2}, 3, 0, 0},
+		{"stock unchanged", Product{Stock: 3}, 3, 0, 0},
+		{"still sold out", Product{Stock: 0}, 0, 0, 0},
+		{"archived", Product{Stock: 3, DeletedAt: &archived}, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := notificationsToQueue(&tt.product, tt.stockBefore, tt.queued)
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"math"
+	"strings"
+)
+
+// TaxClassStandard is the tax class of products that have not been given another one
+const TaxClassStandard = "standard"
+
+// matchTaxRates keeps the rates that apply to an address in their country. Of the rates sharing a
+// name and tax class only the most specific one applies: the one with the longest matching postal
+// code prefix, a matching city breaking ties. A city wide rate can so override a country wide one.
+func matchTaxRates(rates []*TaxRate, postalCode, city string) []*TaxRate {
+	postalCode = strings.ToUpper(strings.ReplaceAll(postalCode, " ", ""))
+
+	type key struct{ name, class string }
+	best := make(map[key]*TaxRate)
+	var order []key
+
+	for _, r := range rates {
+		if !strings.HasPrefix(postalCode, strings.ToUpper(r.PostalPrefix)) {
+			continue
+		}
+		if r.City != "" && !strings.EqualFold(strings.TrimSpace(city), r.City) {
+			continue
+		}
+
+		k := key{r.Name, r.TaxClass}
+		current, ok := best[k]
+		if !ok {
+			order = append(order, k)
+		}
+		if !ok || specificity(r) > specificity(current) {
+			best[k] = r
+		}
+	}
+
+	matched := make([]*TaxRate, 0, len(order))
+	for _, k := range order {
+		matched = append(matched, best[k])
+	}
+
+	return matched
+}
+
+func specificity(r *TaxRate) int {
+	s := 2 * len(r.PostalPrefix)
+	if r.City != "" {
+		s++
+	}
+	return s
+}
+
+// taxLines works out the taxes on an order, one line per tax name and rate. bases are the amounts the cart
+// lines are taxed on and classes their tax classes. With inclusive the tax is part of the bases and
+// is worked out of them; otherwise it comes on top. Amounts are rounded once per tax, not per line.
+func taxLines(bases []Money, classes []string, rates []*TaxRate, inclusive bool, currency string) []TaxLine {
+	type key struct {
+		name string
+		rate float64
+	}
+	lines := []TaxLine{}
+	index := make(map[key]int)
+	var amounts []float64
+
+	for i, base := range bases {
+		var applicable []*TaxRate
+		total := 0.0
+		for _, r := range rates {
+			if r.TaxClass == classes[i] {
+				applicable = append(applicable, r)
+				total += r.Rate
+			}
+		}
+
+		for _, r := range applicable {
+			amount := float64(base.Amount) * r.Rate / 100
+			if inclusive {
+				amount = float64(base.Amount) * r.Rate / (100 + total)
+			}
+
+			k := key{r.Name, r.Rate}
+			j, ok := index[k]
+			if !ok {
+				j = len(lines)
+				index[k] = j
+				lines = append(lines, TaxLine{Name: r.Name, Rate: r.Rate})
+				amounts = append(amounts, 0)
+			}
+			amounts[j] += amount
+		}
+	}
+
+	for i := range lines {
+		lines[i].Amount = Money{Amount: int(math.Round(amounts[i])), Currency: currency}
+	}
+
+	return lines
+}
+
+// taxableBases are the amounts the lines of a priced cart are taxed on: their price times the quantity,
+// less a share of the coupon discount in proportion to the line. Rounding leftovers go to the last line.
+func taxableBases(cp *CartProducts) []Money {
+	bases := make([]Money, len(cp.Price))
+	subtotal := 0
+
+	for i, price := range cp.Price {
+		bases[i] = price.Mul(cp.quantity(i))
+		subtotal += bases[i].Amount
+	}
+	if subtotal == 0 || cp.Discount.Amount == 0 {
+		return bases
+	}
+
+	left := cp.Discount.Amount
+	for i := range bases {
+		share := left
+		if i < len(bases)-1 {
+			share = cp.Discount.Amount * bases[i].Amount / subtotal
+		}
+		bases[i].Amount -= share
+		left -= share
+	}
+
+	return bases
+}
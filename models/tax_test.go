@@ -0,0 +1,172 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchTaxRates(t *testing.T) {
+	state := &TaxRate{ID: 1, Name: "State", TaxClass: TaxClassStandard, Rate: 6}
+	county := &TaxRate{ID: 2, Name: "County", PostalPrefix: "98", TaxClass: TaxClassStandard, Rate: 1}
+	countyNarrow := &TaxRate{ID: 3, Name: "County", PostalPrefix: "981", TaxClass: TaxClassStandard, Rate: 2}
+	city := &TaxRate{ID: 4, Name: "County", PostalPrefix: "981", City: "Seattle", TaxClass: TaxClassStandard, Rate: 3}
+	reduced := &TaxRate{ID: 5, Name: "State", TaxClass: "reduced", Rate: 2}
+	rates := []*TaxRate{state, county, countyNarrow, city, reduced}
+
+	tests := []struct {
+		name       string
+		postalCode string
+		city       string
+		want       []*TaxRate
+	}{
+		{"country wide only", "10001", "New York", []*TaxRate{state, reduced}},
+		{"longest prefix wins", "98201", "Everett", []*TaxRate{state, county, reduced}},
+		{"narrower prefix", "98101", "Tacoma", []*TaxRate{state, countyNarrow, reduced}},
+		{"city breaks ties", "98101", "Seattle", []*TaxRate{state, city, reduced}},
+		{"city ignores case and spaces", "981 01", " seattle ", []*TaxRate{state, city, reduced}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchTaxRates(rates, tt.postalCode, tt.city)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", rateIDs(got), rateIDs(tt.want))
+			}
+		})
+	}
+}
+
+func rateIDs(rates []*TaxRate) []int {
+	ids := make([]int, len(rates))
+	for i, r := range rates {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+func TestTaxLines(t *testing.T) {
+	eur := func(amount int) Money { return Money{Amount: amount, Currency: "EUR"} }
+	vat := &TaxRate{Name: "VAT", TaxClass: TaxClassStandard, Rate: 20}
+	reducedVAT := &TaxRate{Name: "VAT", TaxClass: "reduced", Rate: 5.5}
+	stateTax := &TaxRate{Name: "State", TaxClass: TaxClassStandard, Rate: 6}
+	cityTax := &TaxRate{Name: "City", TaxClass: TaxClassStandard, Rate: 4}
+
+	tests := []struct {
+		name      string
+		bases     []Money
+		classes   []string
+		rates     []*TaxRate
+		inclusive bool
+		want      []TaxLine
+	}{
+		{
+			name:    "on top",
+			bases:   []Money{eur(1000), eur(250)},
+			classes: []string{TaxClassStandard, TaxClassStandard},
+			rates:   []*TaxRate{vat},
+			want:    []TaxLine{{Name: "VAT", Rate: 20, Amount: eur(250)}},
+		},
+		{
+			name:      "included",
+			bases:     []Money{eur(1200)},
+			classes:   []string{TaxClassStandard},
+			rates:     []*TaxRate{vat},
+			inclusive: true,
+			want:      []TaxLine{{Name: "VAT", Rate: 20, Amount: eur(200)}},
+		},
+		{
+			name:    "one line per rate",
+			bases:   []Money{eur(1000), eur(1000)},
+			classes: []string{TaxClassStandard, "reduced"},
+			rates:   []*TaxRate{vat, reducedVAT},
+			want: []TaxLine{
+				{Name: "VAT", Rate: 20, Amount: eur(200)},
+				{Name: "VAT", Rate: 5.5, Amount: eur(55)},
+			},
+		},
+		{
+			name:      "stacked taxes included",
+			bases:     []Money{eur(1100)},
+			classes:   []string{TaxClassStandard},
+			rates:     []*TaxRate{stateTax, cityTax},
+			inclusive: true,
+			want: []TaxLine{
+				{Name: "State", Rate: 6, Amount: eur(60)},
+				{Name: "City", Rate: 4, Amount: eur(40)},
+			},
+		},
+		{
+			name:    "rounded once per tax",
+			bases:   []Money{eur(3), eur(3), eur(3)},
+			classes: []string{TaxClassStandard, TaxClassStandard, TaxClassStandard},
+			rates:   []*TaxRate{vat},
+			want:    []TaxLine{{Name: "VAT", Rate: 20, Amount: eur(2)}},
+		},
+		{
+			name:    "untaxed class",
+			bases:   []Money{eur(1000)},
+			classes: []string{"exempt"},
+			rates:   []*TaxRate{vat},
+			want:    []TaxLine{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := taxLines(tt.bases, tt.classes, tt.rates, tt.inclusive, "EUR")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaxableBases(t *testing.T) {
+	eur := func(amount int) Money { return Money{Amount: amount, Currency: "EUR"} }
+
+	tests := []struct {
+		name     string
+		prices   []Money
+		quantity []string
+		discount Money
+		want     []Money
+	}{
+		{
+			name:     "no discount",
+			prices:   []Money{eur(1000), eur(250)},
+			quantity: []string{"2", "1"},
+			want:     []Money{eur(2000), eur(250)},
+		},
+		{
+			name:     "discount shared in proportion",
+			prices:   []Money{eur(1500), eur(500)},
+			quantity: []string{"1", "1"},
+			discount: eur(200),
+			want:     []Money{eur(1350), eur(450)},
+		},
+		{
+			name:     "leftover to the last line",
+			prices:   []Money{eur(100), eur(100), eur(100)},
+			quantity: []string{"1", "1", "1"},
+			discount: eur(100),
+			want:     []Money{eur(67), eur(67), eur(66)},
+		},
+		{
+			name:     "nothing to share over",
+			prices:   []Money{eur(0)},
+			quantity: []string{"1"},
+			discount: eur(100),
+			want:     []Money{eur(0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &CartProducts{Price: tt.prices, Quantity: tt.quantity, Discount: tt.discount}
+			got := taxableBases(cp)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
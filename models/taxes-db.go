@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const taxRateColumns = `id, name, country, postal_prefix, city, tax_class, rate, created_at, updated_at`
+
+// AllTaxRates returns every tax rate, by country and name
+func (m *DBModel) AllTaxRates() ([]*TaxRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanTaxRates(m.DB.QueryContext(ctx, `select `+taxRateColumns+` from tax_rates order by country, name, id`))
+}
+
+// GetTaxRate returns one tax rate
+func (m *DBModel) GetTaxRate(id int) (*TaxRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rates, err := scanTaxRates(m.DB.QueryContext(ctx, `select `+taxRateColumns+` from tax_rates where id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if len(rates) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return rates[0], nil
+}
+
+func (m *DBModel) InsertTaxRate(r TaxRate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into tax_rates (name, country, postal_prefix, city, tax_class, rate, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $7) returning id`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.Name,
+		strings.ToUpper(r.Country),
+		strings.ToUpper(r.PostalPrefix),
+		r.City,
+		r.TaxClass,
+		r.Rate,
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+func (m *DBModel) UpdateTaxRate(r TaxRate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update tax_rates set name = $1, country = $2, postal_prefix = $3, city = $4, tax_class = $5, rate = $6,
+				updated_at = $7
+			where id = $8`
+
+	return execOne(ctx, m.DB, stmt,
+		r.Name,
+		strings.ToUpper(r.Country),
+		strings.ToUpper(r.PostalPrefix),
+		r.City,
+		r.TaxClass,
+		r.Rate,
+		time.Now(),
+		r.ID,
+	)
+}
+
+func (m *DBModel) DeleteTaxRate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `delete from tax_rates where id = $1`, id)
+}
+
+// applyTax works out the tax on a priced cart for its billing address, and adds it to the total
+// unless prices already include it. Addresses without a country are taken to be in the store country.
+func (m *DBModel) applyTax(ctx context.Context, db dbtx, cp *CartProducts) error {
+	cp.Tax = Money{Currency: m.store.Currency}
+	cp.TaxLines = []TaxLine{}
+	cp.TaxIncluded = m.store.PricesIncludeTax
+
+	country := m.country(cp.BillingInfo)
+	if country == "" {
+		return nil
+	}
+
+	rates, err := scanTaxRates(db.QueryContext(ctx,
+		`select `+taxRateColumns+` from tax_rates where country = $1 order by name, id`, country))
+	if err != nil {
+		return err
+	}
+	rates = matchTaxRates(rates, cp.BillingInfo.PostalCode, cp.BillingInfo.City)
+
+	cp.TaxLines = taxLines(taxableBases(cp), cp.taxClasses, rates, cp.TaxIncluded, m.store.Currency)
+	for _, line := range cp.TaxLines {
+		cp.Tax = cp.Tax.Add(line.Amount)
+	}
+	if !cp.TaxIncluded {
+		cp.Total = cp.Total.Add(cp.Tax)
+	}
+
+	return nil
+}
+
+// repriceOrder works the shipping and tax on an order out again for the billing address it has
+// been given, replacing those it was placed with. It returns ErrShippingUnavailable if the
+// shipping method of the order does not ship to the address.
+func (m *DBModel) repriceOrder(ctx context.Context, tx dbtx, b BillingInfo) error {
+	cp := CartProducts{ID: b.OrderID, BillingInfo: b}
+	var tax, shipping Money
+	var taxIncluded bool
+
+	query := `select product_id, product_price, quantity, total, discount, tax, tax_included,
+				shipping, coalesce(shipping_method_id, 0), free_shipping
+			from orders where id = $1 for update`
+
+	err := tx.QueryRowContext(ctx, query, b.OrderID).Scan(
+		pq.Array(&cp.ProductID),
+		pq.Array(&cp.Price),
+		pq.Array(&cp.Quantity),
+		&cp.Total,
+		&cp.Discount,
+		&tax,
+		&taxIncluded,
+		&shipping,
+		&cp.ShippingMethodID,
+		&cp.FreeShipping,
+	)
+	if err != nil {
+		return err
+	}
+	cp.Total = cp.Total.Sub(shipping)
+	if !taxIncluded {
+		cp.Total = cp.Total.Sub(tax)
+	}
+
+	cp.taxClasses, err = productTaxClasses(ctx, tx, cp.ProductID)
+	if err != nil {
+		return err
+	}
+
+	err = orderWeight(ctx, tx, &cp)
+	if err != nil {
+		return err
+	}
+
+	err = m.applyShipping(ctx, tx, &cp)
+	if err != nil {
+		return err
+	}
+
+	err = m.applyTax(ctx, tx, &cp)
+	if err != nil {
+		return err
+	}
+
+	taxLines, err := json.Marshal(cp.TaxLines)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update orders set total = $1, tax = $2, tax_lines = $3, tax_included = $4,
+				shipping = $5, shipping_method_id = $6, shipping_method = $7
+			where id = $8`
+
+	return execOne(ctx, tx, stmt, cp.Total, cp.Tax, taxLines, cp.TaxIncluded,
+		cp.Shipping, nullID(cp.ShippingMethodID), nullString(cp.ShippingMethod), cp.ID)
+}
+
+// productTaxClasses returns the tax classes of order lines, archived products included
+func productTaxClasses(ctx context.Context, db dbtx, productIDs []string) ([]string, error) {
+	classes := make([]string, len(productIDs))
+
+	for i, productID := range productIDs {
+		classes[i] = TaxClassStandard
+
+		id, err := strconv.Atoi(productID)
+		if err != nil {
+			continue
+		}
+
+		err = db.QueryRowContext(ctx, `select tax_class from products where id = $1`, id).Scan(&classes[i])
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	return classes, nil
+}
+
+func scanTaxRates(rows *sql.Rows, err error) ([]*TaxRate, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*TaxRate{}
+
+	for rows.Next() {
+		var r TaxRate
+		err := rows.Scan(
+			&r.ID,
+			&r.Name,
+			&r.Country,
+			&r.PostalPrefix,
+			&r.City,
+			&r.TaxClass,
+			&r.Rate,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, &r)
+	}
+
+	return rates, rows.Err()
+}
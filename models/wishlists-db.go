@@ -0,0 +1,336 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// ErrWishlistExists is returned when a user already has a wishlist of the given name
+var ErrWishlistExists = errors.New("wishlist already exists")
+
+// DefaultWishlistName is the name of the wishlist created when a user saves a product without
+// naming a list
+const DefaultWishlistName = "Wishlist"
+
+// Wishlists returns the wishlists of a user, oldest first, with their items
+func (m *DBModel) Wishlists(userID int) ([]*Wishlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.wishlists(ctx, "user_id = $1", userID)
+}
+
+// GetWishlist returns a wishlist of a user. Wishlists of other users are not found.
+func (m *DBModel) GetWishlist(userID, id int) (*Wishlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.wishlist(ctx, "id = $1 and user_id = $2", id, userID)
+}
+
+// DefaultWishlist returns the oldest wishlist of a user, the one products are saved to when no
+// list is named. A user without wishlists gets an empty one, not saved until a product is added.
+func (m *DBModel) DefaultWishlist(userID int) (*Wishlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	lists, err := m.wishlists(ctx, "user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return &Wishlist{UserID: userID, Name: DefaultWishlistName, Items: []*WishlistItem{}}, nil
+	}
+
+	return lists[0], nil
+}
+
+// SharedWishlist returns the wishlist a share token was made for
+func (m *DBModel) SharedWishlist(token string) (*Wishlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.wishlist(ctx, "share_token = $1", token)
+}
+
+// InsertWishlist creates a wishlist for a user, shared when share is set
+func (m *DBModel) InsertWishlist(w Wishlist, share bool) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertWishlist(ctx, tx, w.UserID, w.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setWishlistSharing(ctx, tx, id, share)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// UpdateWishlist renames a wishlist of a user and turns sharing it on or off. Turning sharing on
+// keeps the share token of a list already shared, so that links given out keep working.
+func (m *DBModel) UpdateWishlist(w Wishlist, share bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = execOne(ctx, tx, `update wishlists set name = $1, updated_at = $2 where id = $3 and user_id = $4`,
+		w.Name, time.Now(), w.ID, w.UserID)
+	if err != nil {
+		return wishlistNameTaken(err, w.Name)
+	}
+
+	err = setWishlistSharing(ctx, tx, w.ID, share)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteWishlist deletes a wishlist of a user with its items
+func (m *DBModel) DeleteWishlist(userID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execOne(ctx, m.DB, `delete from wishlists where id = $1 and user_id = $2`, id, userID)
+}
+
+// AddWishlistItem saves a product to a wishlist of a user, at its current price, and returns the
+// id of the list. A listID of 0 names the default wishlist, which is created if need be. Saving
+// a product already on the list changes nothing.
+func (m *DBModel) AddWishlistItem(userID, listID, productID int, size string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := wishlistID(ctx, tx, userID, listID)
+	if errors.Is(err, sql.ErrNoRows) && listID == 0 {
+		id, err = insertWishlist(ctx, tx, userID, DefaultWishlistName)
+	}
+	if err != nil {
+		return 0, err
+	}
+	listID = id
+
+	product, err := m.scanProduct(tx.QueryRowContext(ctx,
+		`select `+productColumns+` from products where id = $1 and deleted_at is null`, productID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: %d", ErrUnknownProduct, productID)
+	} else if err != nil {
+		return 0, err
+	}
+
+	discounts, err := m.productDiscounts(ctx, productID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into wishlist_items (wishlist_id, product_id, size, added_price, added_at) values ($1, $2, $3, $4, $5)
+			on conflict (wishlist_id, product_id, size) do nothing`
+
+	_, err = tx.ExecContext(ctx, stmt, listID, productID, size, EffectivePrice(product, discounts, time.Now()).Price, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update wishlists set updated_at = $1 where id = $2`, time.Now(), listID)
+	if err != nil {
+		return 0, err
+	}
+
+	return listID, tx.Commit()
+}
+
+// RemoveWishlistItem takes a product off a wishlist of a user. A listID of 0 names the default
+// wishlist. It returns sql.ErrNoRows when the product is not on the list.
+func (m *DBModel) RemoveWishlistItem(userID, listID, productID int, size string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	listID, err := wishlistID(ctx, m.DB, userID, listID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = execOne(ctx, m.DB, `delete from wishlist_items where wishlist_id = $1 and product_id = $2 and size = $3`,
+		listID, productID, size)
+
+	return listID, err
+}
+
+// flagWishlistItems updates the back in stock flag of the wishlist items of a product after it
+// changed. An item is back in stock when the product was sold out and no longer is, until it
+// sells out again.
+func flagWishlistItems(ctx context.Context, tx dbtx, product *Product, stockBefore int) error {
+	stmt := `update wishlist_items set
+				back_in_stock = case when $2 <= 0 then false when $3 <= 0 then true else back_in_stock end
+			where product_id = $1`
+
+	_, err := tx.ExecContext(ctx, stmt, product.ID, product.Stock, stockBefore)
+	return err
+}
+
+// wishlistID checks that a wishlist belongs to a user and returns its id. A listID of 0 names the
+// default wishlist of the user.
+func wishlistID(ctx context.Context, db dbtx, userID, listID int) (int, error) {
+	var err error
+	if listID == 0 {
+		err = db.QueryRowContext(ctx, `select id from wishlists where user_id = $1 order by id limit 1`, userID).Scan(&listID)
+	} else {
+		err = db.QueryRowContext(ctx, `select id from wishlists where id = $1 and user_id = $2`, listID, userID).Scan(&listID)
+	}
+
+	return listID, err
+}
+
+func insertWishlist(ctx context.Context, tx dbtx, userID int, name string) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `insert into wishlists (user_id, name, created_at, updated_at) values ($1, $2, $3, $3) returning id`,
+		userID, name, time.Now()).Scan(&id)
+
+	return id, wishlistNameTaken(err, name)
+}
+
+// wishlistNameTaken turns the error of saving a wishlist under a name the user already has for
+// another list into ErrWishlistExists. The unique constraint decides, so that lists saved at the
+// same time cannot both take the name.
+func wishlistNameTaken(err error, name string) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "wishlists_user_id_name_key" {
+		return fmt.Errorf("%w: %q", ErrWishlistExists, name)
+	}
+
+	return err
+}
+
+func setWishlistSharing(ctx context.Context, tx dbtx, id int, share bool) error {
+	if !share {
+		_, err := tx.ExecContext(ctx, `update wishlists set share_token = null where id = $1`, id)
+		return err
+	}
+
+	token, err := newLookupToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update wishlists set share_token = coalesce(share_token, $1) where id = $2`, token, id)
+	return err
+}
+
+func (m *DBModel) wishlist(ctx context.Context, filter string, args ...interface{}) (*Wishlist, error) {
+	lists, err := m.wishlists(ctx, filter, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return lists[0], nil
+}
+
+// wishlists returns the wishlists matching the filter condition, oldest first, with their items
+// priced now. Archived products stay on the lists, out of stock.
+func (m *DBModel) wishlists(ctx context.Context, filter string, args ...interface{}) ([]*Wishlist, error) {
+	query := `select id, user_id, name, coalesce(share_token, ''), created_at, updated_at
+			from wishlists where ` + filter + ` order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*Wishlist{}
+
+	for rows.Next() {
+		w := Wishlist{Items: []*WishlistItem{}}
+		err := rows.Scan(&w.ID, &w.UserID, &w.Name, &w.ShareToken, &w.CreatedAt, &w.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, &w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, w := range lists {
+		err = m.wishlistItems(ctx, w)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return lists, nil
+}
+
+func (m *DBModel) wishlistItems(ctx context.Context, w *Wishlist) error {
+	rows, err := m.DB.QueryContext(ctx, `select product_id, size, added_price, back_in_stock, added_at
+			from wishlist_items where wishlist_id = $1 order by added_at, product_id, size`, w.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item WishlistItem
+		err := rows.Scan(&item.ProductID, &item.Size, &item.AddedPrice, &item.BackInStock, &item.AddedAt)
+		if err != nil {
+			return err
+		}
+		item.AddedPrice.Currency = m.store.Currency
+		w.Items = append(w.Items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, item := range w.Items {
+		product, err := m.scanProduct(m.DB.QueryRowContext(ctx, `select `+productColumns+` from products where id = $1`, item.ProductID))
+		if err != nil {
+			return err
+		}
+
+		discounts, err := m.productDiscounts(ctx, item.ProductID)
+		if err != nil {
+			return err
+		}
+
+		item.Title = product.Title
+		item.Price = EffectivePrice(product, discounts, now).Price
+		item.PriceDropped = item.Price.Amount < item.AddedPrice.Amount
+		item.InStock = product.DeletedAt == nil && product.Stock > 0
+	}
+
+	return nil
+}
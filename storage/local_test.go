@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalStore(filepath.Join(dir, "images"), "/static/images/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	err = s.Put(ctx, "products/1/original.png", []byte("png"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "images", "products", "1", "original.png"))
+	if err != nil || string(got) != "png" {
+		t.Fatalf("read back %q, %v", got, err)
+	}
+	if url := s.URL("products/1/original.png"); url != "/static/images/products/1/original.png" {
+		t.Errorf("URL = %q", url)
+	}
+
+	err = s.Delete(ctx, "products/1/original.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Delete(ctx, "products/1/original.png")
+	if err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+
+	err = s.Put(ctx, "../outside.png", []byte("png"), "image/png")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("put outside the store: got %v, want %v", err, ErrInvalidKey)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside.png")); !os.IsNotExist(err) {
+		t.Error("put wrote outside the store")
+	}
+}
@@ -0,0 +1,140 @@
+//go:build integration
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// The S3 store is tested against a running MinIO, or any S3 compatible service:
+//
+//	docker run -d -p 9000:9000 -e MINIO_ROOT_USER=minioadmin -e MINIO_ROOT_PASSWORD=minioadmin \
+//		minio/minio server /data
+//	S3_TEST_ENDPOINT=http://localhost:9000 S3_ACCESS_KEY=minioadmin S3_SECRET_KEY=minioadmin \
+//		go test -tags integration ./storage/
+func testS3Store(t *testing.T) *S3Store {
+	t.Helper()
+
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+	bucket := os.Getenv("S3_TEST_BUCKET")
+	if bucket == "" {
+		bucket = "ecom-api-test"
+	}
+
+	s := &S3Store{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    bucket,
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+
+	resp := s3Request(t, s, http.MethodPut, "/"+awsEscape(bucket), nil)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusConflict {
+		t.Fatalf("create bucket %s: %s", bucket, resp.Status)
+	}
+
+	return s
+}
+
+// s3Request sends a request signed the way the store signs its own
+func s3Request(t *testing.T, s *S3Store, method, path string, body []byte) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), method, s.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, path, body, time.Now().UTC())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestS3StorePutDelete(t *testing.T) {
+	s := testS3Store(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"plain key", "products/1/original.png"},
+		{"key to escape", "products/1/a photo+(1)~.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte("not really a png: " + tt.name)
+			path := "/" + awsEscape(s.Bucket) + "/" + awsEscapePath(tt.key)
+
+			err := s.Put(ctx, tt.key, data, "image/png")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := s3Request(t, s, http.MethodGet, path, nil)
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("get %s: %s", tt.key, resp.Status)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("got %q, want %q", got, data)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+				t.Errorf("content type %q, want image/png", ct)
+			}
+
+			err = s.Delete(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp = s3Request(t, s, http.MethodGet, path, nil)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("get %s after delete: %s, want 404", tt.key, resp.Status)
+			}
+		})
+	}
+}
+
+func TestS3StoreInvalidKey(t *testing.T) {
+	s := testS3Store(t)
+
+	err := s.Put(context.Background(), "../escape.png", []byte("x"), "image/png")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("got %v, want %v", err, ErrInvalidKey)
+	}
+}
+
+func TestS3StoreWrongCredentials(t *testing.T) {
+	s := testS3Store(t)
+	s.SecretKey += "wrong"
+
+	err := s.Put(context.Background(), "products/1/denied.png", []byte("x"), "image/png")
+	if err == nil {
+		t.Error("put with a wrong secret key succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestS3StoreSign(t *testing.T) {
+	s := &S3Store{Region: "us-east-1", AccessKey: "access", SecretKey: "secret"}
+
+	req, err := http.NewRequest(http.MethodPut, "http://localhost:9000/images/products/1/a%20b.png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sign(req, "/images/products/1/a%20b.png", []byte("hello"), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	want := map[string]string{
+		"X-Amz-Date":           "20240102T030405Z",
+		"X-Amz-Content-Sha256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"Authorization": "AWS4-HMAC-SHA256 Credential=access/20240102/us-east-1/s3/aws4_request, " +
+			"SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+			"Signature=7af3e46376b67fadfdbb6899b451f666fe8b90c739d1316b2e0dafa0224ab770",
+	}
+
+	for name, value := range want {
+		if got := req.Header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestAWSEscapePath(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"products/1/original.png", "products/1/original.png"},
+		{"products/1/a b.png", "products/1/a%20b.png"},
+		{"products/1/a+(1)~.png", "products/1/a%2B%281%29~.png"},
+		{"products/1/é.png", "products/1/%C3%A9.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := awsEscapePath(tt.key); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		store S3Store
+		want  string
+	}{
+		{"endpoint", S3Store{Endpoint: "http://localhost:9000/", Bucket: "images"}, "http://localhost:9000/images/products/1/a.png"},
+		{"public URL", S3Store{Endpoint: "http://localhost:9000", Bucket: "images", PublicURL: "https://cdn.example.com/"},
+			"https://cdn.example.com/products/1/a.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.store.URL("products/1/a.png"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
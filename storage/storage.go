@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path"
+	"strings"
+)
+
+// ErrInvalidKey is returned when a key would escape the store
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// ImageStore saves images under opaque keys and knows the public URL of each key
+type ImageStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// NewKey returns a random key under prefix with the given file extension
+func NewKey(prefix, ext string) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(prefix, hex.EncodeToString(b)+ext), nil
+}
+
+// cleanKey checks that a key is relative and stays inside the store
+func cleanKey(key string) (string, error) {
+	clean := path.Clean("/" + key)[1:]
+	if clean == "" || clean != strings.TrimPrefix(key, "/") {
+		return "", ErrInvalidKey
+	}
+
+	return clean, nil
+}
+
+// joinURL joins a base URL and a key
+func joinURL(base, key string) string {
+	if key == "" {
+		return ""
+	}
+
+	return strings.TrimRight(base, "/") + "/" + key
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCleanKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"products/1/original.png", "products/1/original.png", false},
+		{"/products/1/original.png", "products/1/original.png", false},
+		{"products/1/../2/original.png", "", true},
+		{"../escape.png", "", true},
+		{"products//1.png", "", true},
+		{"products/1/", "", true},
+		{"", "", true},
+		{"/", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := cleanKey(tt.key)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Fatalf("got %q, %v, want %v", got, err, ErrInvalidKey)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	a, err := NewKey("products/1", ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewKey("products/1", ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(a, "products/1/") || !strings.HasSuffix(a, ".png") {
+		t.Errorf("got %q, want products/1/*.png", a)
+	}
+	if a == b {
+		t.Errorf("two keys are both %q", a)
+	}
+	if _, err := cleanKey(a); err != nil {
+		t.Errorf("new key %q is not clean: %v", a, err)
+	}
+}